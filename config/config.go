@@ -10,16 +10,69 @@ import (
 )
 
 type Config struct {
-	Listen string
-	Region string
-	Filter types.FilterType
-	Redis  RedisConfig
+	Listen    string
+	Region    string
+	Filter    types.FilterType
+	Mask      string
+	Store     types.StoreType
+	Redis     RedisConfig
+	File      FileConfig
+	Data      DataConfig
+	Normalize NormalizeConfig
+	Tenant    map[string]TenantConfig
+	Auth      AuthConfig
+	RESP      RESPConfig
 }
 
 type RedisConfig struct {
 	DSN string `toml:"dsn"`
 }
 
+type FileConfig struct {
+	Dir     string `toml:"dir"`
+	Compact int    `toml:"compact"`
+}
+
+// DataConfig names the directory of bundled word files, such as data/en,
+// which are imported into the wordlist store at startup.
+type DataConfig struct {
+	Dir   string            `toml:"dir"`
+	Mode  types.ImportMode  `toml:"mode"`
+	Langs map[string]string `toml:"langs"`
+}
+
+// NormalizeConfig enables normalization of text before it is matched against
+// the blacklist. LeetDir names a directory of per language substitution
+// tables, such as leet/en_US or leet/en.
+type NormalizeConfig struct {
+	Leet        bool   `toml:"leet"`
+	LeetDir     string `toml:"leet_dir"`
+	Invisible   bool   `toml:"invisible"`
+	NFKC        bool   `toml:"nfkc"`
+	Diacritics  bool   `toml:"diacritics"`
+	Confusables bool   `toml:"confusables"`
+}
+
+// TenantConfig gives a tenant its own lists, which other tenants can not
+// change. Requests carry the API key of their tenant in the X-Api-Key header.
+// Inherit filters the words of the global lists as well as the tenant's own.
+type TenantConfig struct {
+	Keys    []string `toml:"keys"`
+	Inherit bool     `toml:"inherit"`
+}
+
+// AuthConfig requires requests to carry an API key of KeysFile, either in the
+// X-Api-Key header or as the key of an HMAC signature.
+type AuthConfig struct {
+	KeysFile string `toml:"keys_file"`
+}
+
+// RESPConfig enables a listener which serves the filters over the Redis
+// protocol.
+type RESPConfig struct {
+	Listen string `toml:"listen"`
+}
+
 func ReadFile(filename string) (*Config, error) {
 	config := new(Config)
 	_, err := toml.DecodeFile(filename, config)
@@ -3,14 +3,28 @@ package db
 import (
 	"net/url"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
 type DB struct {
-	cfg  *config
-	pool *redis.Pool
+	cfg        *config
+	pool       *redis.Pool
+	dials      int64
+	dialErrors int64
+}
+
+// Stats are the statistics of the connection pool.
+type Stats struct {
+	// Active is the number of open connections, idle or in use.
+	Active  int
+	MaxIdle int
+	// Dials is the number of connections opened, and DialErrors the number
+	// of connections which failed to open.
+	Dials      int64
+	DialErrors int64
 }
 
 type config struct {
@@ -83,8 +97,26 @@ func (db *DB) Get() redis.Conn {
 	return db.pool.Get()
 }
 
-func (db *DB) dial() (redis.Conn, error) {
-	conn, err := redis.Dial("tcp", db.cfg.addr)
+// Stats returns the statistics of the connection pool.
+func (db *DB) Stats() Stats {
+	return Stats{
+		Active:     db.pool.ActiveCount(),
+		MaxIdle:    db.pool.MaxIdle,
+		Dials:      atomic.LoadInt64(&db.dials),
+		DialErrors: atomic.LoadInt64(&db.dialErrors),
+	}
+}
+
+func (db *DB) dial() (conn redis.Conn, err error) {
+	atomic.AddInt64(&db.dials, 1)
+
+	defer func() {
+		if err != nil {
+			atomic.AddInt64(&db.dialErrors, 1)
+		}
+	}()
+
+	conn, err = redis.Dial("tcp", db.cfg.addr)
 
 	if err != nil {
 		return nil, err
@@ -17,9 +17,16 @@ import (
 var (
 	help           = flag.Bool("h", false, "show help text")
 	laddr          = flag.String("http", ":6061", "set bind address for the HTTP server")
+	respAddr       = flag.String("resp", "", "set bind address for the Redis protocol listener")
 	dsn            = flag.String("redis", "redis://:@localhost:6379/15", "Redis data source name")
-	filterType     = flag.String("filter", "", "filter type")
+	filterType     = flag.String("filter", "", "filter type (word, any, ahocorasick)")
+	mask           = flag.String("mask", "", "default mask (stars, keepends, grawlix, remove, rune:#, token:[censored])")
+	storeType      = flag.String("store", "", "wordlist store (redis, memory, file)")
+	fileDir        = flag.String("file.dir", "wordlists", "wordlist directory for the file store")
 	configFilename = flag.String("config", "config.toml", "config file path")
+	dataDir        = flag.String("data.dir", "", "seed empty wordlists from word files in directory")
+	importMode     = flag.String("data.mode", "", "seed mode (empty, merge, overwrite)")
+	importOnly     = flag.Bool("import", false, "seed wordlists from the data directory and exit")
 	cpuprofile     = flag.String("debug.cpuprofile", "", "write cpu profile to file")
 )
 
@@ -51,17 +58,48 @@ func main() {
 		conf.Listen = *laddr
 	}
 
+	if conf.RESP.Listen == "" {
+		conf.RESP.Listen = *respAddr
+	}
+
 	if conf.Redis.DSN == "" {
 		conf.Redis.DSN = *dsn
 	}
 
 	if *filterType != "" {
-		switch strings.ToLower(*filterType) {
-		case "any":
-			conf.Filter = types.Any
-		default:
-			conf.Filter = types.Word
+		conf.Filter = types.FilterType(strings.ToLower(*filterType))
+	}
+
+	if *mask != "" {
+		conf.Mask = *mask
+	}
+
+	if *storeType != "" {
+		conf.Store = types.StoreType(strings.ToLower(*storeType))
+	}
+
+	if conf.File.Dir == "" {
+		conf.File.Dir = *fileDir
+	}
+
+	if *dataDir != "" {
+		conf.Data.Dir = *dataDir
+	}
+
+	if *importMode != "" {
+		conf.Data.Mode = types.ImportMode(strings.ToLower(*importMode))
+	}
+
+	if *importOnly {
+		if conf.Data.Dir == "" {
+			log.Fatal("Data directory required")
 		}
+
+		if err := server.Import(conf); err != nil {
+			log.Fatal(err)
+		}
+
+		return
 	}
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of an API key. Read allows the sanitize APIs and reading lists,
+// admin allows changing lists as well.
+const (
+	scopeRead  = "read"
+	scopeAdmin = "admin"
+)
+
+// maxClockSkew is how far the timestamp of a signed request may be from the
+// server's clock.
+const maxClockSkew = 5 * time.Minute
+
+var (
+	errNoCredentials  = errors.New("Missing API key")
+	errBadCredentials = errors.New("Invalid API key")
+	errBadSignature   = errors.New("Invalid signature")
+	errBodyTooLarge   = errors.New("Request body too large")
+)
+
+// apiKey is an entry of the keys file.
+type apiKey struct {
+	name   string
+	secret string
+	scopes map[string]bool
+	tenant string
+}
+
+// allows reports whether the key grants scope.
+func (k *apiKey) allows(scope string) bool {
+	return k.scopes[scope] || k.scopes[scopeAdmin]
+}
+
+// keySet authenticates requests by the API keys of a keys file.
+type keySet struct {
+	byName   map[string]*apiKey
+	bySecret map[[sha256.Size]byte]*apiKey
+	now      func() time.Time
+}
+
+// readKeys reads a keys file with one key per line: the name of the key, the
+// secret, the comma separated scopes and optionally the tenant of the key.
+// Blank lines and lines starting with # are skipped.
+//
+//	# name  secret            scopes      tenant
+//	web     3f9a1c0e...       read
+//	ops     b71c5d2a...       read,admin
+//	team-a  0c4e7f19...       read,admin  team-a
+func readKeys(r io.Reader) (*keySet, error) {
+	s := &keySet{
+		byName:   make(map[string]*apiKey),
+		bySecret: make(map[[sha256.Size]byte]*apiKey),
+		now:      time.Now,
+	}
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected name, secret, scopes and tenant", n)
+		}
+
+		k := &apiKey{name: fields[0], secret: fields[1], scopes: make(map[string]bool)}
+
+		for _, scope := range strings.Split(fields[2], ",") {
+			if scope != scopeRead && scope != scopeAdmin {
+				return nil, fmt.Errorf("line %d: unknown scope %q", n, scope)
+			}
+
+			k.scopes[scope] = true
+		}
+
+		if len(fields) == 4 {
+			if !validListName(fields[3]) {
+				return nil, fmt.Errorf("line %d: invalid tenant name %q", n, fields[3])
+			}
+
+			k.tenant = fields[3]
+		}
+
+		if _, ok := s.byName[k.name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %s", n, k.name)
+		}
+
+		s.byName[k.name] = k
+		s.bySecret[sha256.Sum256([]byte(k.secret))] = k
+	}
+
+	return s, scanner.Err()
+}
+
+func readKeysFile(filename string) (*keySet, error) {
+	f, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	s, err := readKeys(f)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	return s, nil
+}
+
+// authenticate returns the key of the request. The request either carries
+// the secret in the X-Api-Key header, or is signed with it:
+//
+//	Authorization: HMAC <name>:<signature>
+//	X-Timestamp: <unix time>
+//
+// where the signature is the hex encoded HMAC-SHA256 of the method, request
+// URI, timestamp and body, each followed by a newline except the body.
+func (s *keySet) authenticate(r *http.Request) (*apiKey, error) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "HMAC ") {
+		return s.verify(r, strings.TrimPrefix(auth, "HMAC "))
+	}
+
+	secret := r.Header.Get(tenantHeader)
+
+	if secret == "" {
+		return nil, errNoCredentials
+	}
+
+	if k, ok := s.bySecret[sha256.Sum256([]byte(secret))]; ok {
+		return k, nil
+	}
+
+	return nil, errBadCredentials
+}
+
+// verify checks the signature of a signed request.
+func (s *keySet) verify(r *http.Request, credentials string) (*apiKey, error) {
+	i := strings.LastIndex(credentials, ":")
+
+	if i < 0 {
+		return nil, errBadSignature
+	}
+
+	k, ok := s.byName[credentials[:i]]
+
+	if !ok {
+		return nil, errBadCredentials
+	}
+
+	sig, err := hex.DecodeString(credentials[i+1:])
+
+	if err != nil {
+		return nil, errBadSignature
+	}
+
+	timestamp := r.Header.Get("X-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+
+	if err != nil {
+		return nil, errBadSignature
+	}
+
+	if skew := s.now().Sub(time.Unix(unix, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errBadSignature
+	}
+
+	var body []byte
+
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(body) > maxBodySize {
+			return nil, errBodyTooLarge
+		}
+
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if !hmac.Equal(sig, sign(k.secret, r.Method, r.URL.RequestURI(), timestamp, body)) {
+		return nil, errBadSignature
+	}
+
+	return k, nil
+}
+
+// sign returns the signature of a request.
+func sign(secret, method, uri, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", method, uri, timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// authorize requires a key with scope for h when keys are configured. The
+// tenant of the key, if any, is the tenant of the request.
+func authorize(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if keys == nil {
+			h(w, r)
+			return
+		}
+
+		k, err := keys.authenticate(r)
+
+		if err == errBodyTooLarge {
+			authError(w, r, err.Error(), 413)
+			return
+		}
+
+		if err != nil {
+			authError(w, r, err.Error(), 401)
+			return
+		}
+
+		if !k.allows(scope) {
+			authError(w, r, fmt.Sprintf("Key %s lacks the %s scope", k.name, scope), 403)
+			return
+		}
+
+		if k.tenant != "" {
+			r = r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, k.tenant))
+		}
+
+		h(w, r)
+	}
+}
+
+// authError writes an error of the key checks. Unlike jsonError the v1 body
+// is valid JSON, as no existing client depends on the format of these errors.
+func authError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		v2ErrorOf(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&errorResponse{Error: message, Code: status})
+}
@@ -3,52 +3,296 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/gorilla/mux"
 	"github.com/simonz05/profanity/wordfilter"
 	"github.com/simonz05/profanity/wordlist"
 	"github.com/simonz05/util/log"
 )
 
+// defaultList is the list used when a request names no list. It is the list
+// of the blacklist API.
+const defaultList = "default"
+
+// filterKey identifies the filter of a combination of lists of a tenant and
+// lang.
+type filterKey struct {
+	tenant string
+	lang   string
+	lists  string // sorted, comma separated list names
+}
+
+func newFilterKey(tenant, lang string, lists []string) filterKey {
+	if len(lists) == 0 {
+		return filterKey{tenant: tenant, lang: lang, lists: defaultList}
+	}
+
+	names := append([]string(nil), lists...)
+	sort.Strings(names)
+	n := 1
+
+	for i := 1; i < len(names); i++ {
+		if names[i] != names[n-1] {
+			names[n] = names[i]
+			n++
+		}
+	}
+
+	return filterKey{tenant: tenant, lang: lang, lists: strings.Join(names[:n], ",")}
+}
+
+// maxFilters is the number of filters kept by default. Any client can name
+// a lang or list, so the least recently used filter is evicted beyond it.
+const maxFilters = 1024
+
 type profanityFilters struct {
-	lang *map[string]wordfilter.ProfanityFilter
-	mu   sync.RWMutex
+	filters *map[filterKey]*cachedFilter
+	stores  map[string]wordlist.Wordlist // lists shared by the filters
+	max     int                          // filters kept
+	clock   int64                        // accessed atomically
+	mu      sync.RWMutex
+}
+
+// cachedFilter is a filter of the cache and the keys of its stores.
+type cachedFilter struct {
+	filter wordfilter.ProfanityFilter
+	stores []string
+	used   int64 // clock of the last get, accessed atomically
 }
 
 func newProfanityFilters() *profanityFilters {
-	m := new(map[string]wordfilter.ProfanityFilter)
+	m := make(map[filterKey]*cachedFilter)
 	return &profanityFilters{
-		lang: m,
+		filters: &m,
+		stores:  make(map[string]wordlist.Wordlist),
+		max:     maxFilters,
 	}
 }
 
-func (s *profanityFilters) addLang(lang string) wordfilter.ProfanityFilter {
+func (s *profanityFilters) add(k filterKey) *cachedFilter {
 	s.mu.Lock()
-	m := make(map[string]wordfilter.ProfanityFilter, len(*s.lang))
 
-	for k, v := range *(s.lang) {
+	if c, ok := (*s.filters)[k]; ok {
+		s.mu.Unlock()
+		return c
+	}
+
+	m := make(map[filterKey]*cachedFilter, len(*s.filters)+1)
+
+	for k, v := range *(s.filters) {
 		m[k] = v
 	}
 
-	list := wordlist.NewRedisWordlist(dbConn, lang)
-	f := newWordfilter(list)
-	m[lang] = f
-	s.lang = &m
+	c := new(cachedFilter)
+	list := s.lists(c, k.tenant, k.lang, k.lists)
+	allow := s.store(c, k.tenant+"/allowlist:"+k.lang, func() wordlist.Wordlist {
+		return newAllowlist(k.tenant, k.lang)
+	})
+
+	f := newWordfilter(k.lang, list, allow)
+	f.Observer = filterObserver{k}
+
+	if tenants.inherits(k.tenant) {
+		f.Base = s.lists(c, globalTenant, k.lang, k.lists)
+	}
+
+	c.filter = f
+
+	m[k] = c
+
+	if len(m) > s.max {
+		s.evict(m, k)
+	}
+
+	s.filters = &m
 	s.mu.Unlock()
 	f.Reload()
-	return f
+	return c
+}
+
+// evict removes the least recently used filter other than the added filter
+// from m, and closes the stores no other filter uses. Stores of the memory
+// store hold the only copy of their words and are kept unless empty. It
+// must be called with s.mu held.
+func (s *profanityFilters) evict(m map[filterKey]*cachedFilter, added filterKey) {
+	var oldest filterKey
+	used := int64(-1)
+
+	for k, c := range m {
+		if k == added {
+			continue
+		}
+
+		if u := atomic.LoadInt64(&c.used); used < 0 || u < used {
+			oldest, used = k, u
+		}
+	}
+
+	evicted := m[oldest]
+	delete(m, oldest)
+	inUse := make(map[string]bool)
+
+	for _, c := range m {
+		for _, key := range c.stores {
+			inUse[key] = true
+		}
+	}
+
+	for _, key := range evicted.stores {
+		if inUse[key] {
+			continue
+		}
+
+		list := s.stores[key]
+
+		if list, ok := list.(*wordlist.MemoryWordlist); ok {
+			if n, err := list.Count(); err != nil || n > 0 {
+				continue
+			}
+		}
+
+		if closer, ok := list.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Errorf("close %s: %v", key, err)
+			}
+		}
+
+		delete(s.stores, key)
+	}
+}
+
+// lists returns the combined lists of tenant and lang of c. It must be
+// called with s.mu held.
+func (s *profanityFilters) lists(c *cachedFilter, tenant, lang, lists string) wordlist.Wordlist {
+	names := strings.Split(lists, ",")
+	res := make([]wordlist.Wordlist, len(names))
+
+	for i, name := range names {
+		res[i] = s.store(c, tenant+"/list:"+name+":"+lang, func() wordlist.Wordlist {
+			return newWordlist(tenant, name, lang)
+		})
+	}
+
+	if len(res) == 1 {
+		return res[0]
+	}
+
+	return wordlist.NewUnionWordlist(res...)
 }
 
-func (s *profanityFilters) get(lang string) wordfilter.ProfanityFilter {
-	f, ok := (*s.lang)[lang]
+// store returns the list stored under key for c, opening it on first use. A
+// list is opened once and shared by all filters which use it. It must be
+// called with s.mu held.
+func (s *profanityFilters) store(c *cachedFilter, key string, open func() wordlist.Wordlist) wordlist.Wordlist {
+	list, ok := s.stores[key]
 
 	if !ok {
-		f = s.addLang(lang)
+		list = open()
+		s.stores[key] = list
 	}
 
-	return f
+	c.stores = append(c.stores, key)
+	return list
+}
+
+// get returns the filter of the combined lists of tenant and lang. No lists
+// means the default list. The filter of each combination is built once and
+// kept while it is among the most recently used.
+func (s *profanityFilters) get(tenant, lang string, lists ...string) wordfilter.ProfanityFilter {
+	k := newFilterKey(tenant, lang, lists)
+	c, ok := (*s.filters)[k]
+
+	if !ok {
+		c = s.add(k)
+	}
+
+	atomic.StoreInt64(&c.used, atomic.AddInt64(&s.clock, 1))
+	return c.filter
+}
+
+// reload rebuilds the loaded filters of lang of all tenants.
+func (s *profanityFilters) reload(lang string) {
+	s.changed(lang, nil)
+}
+
+// changed rebuilds the loaded filters of lang other than f, after the lists
+// of lang were changed through f. Filters of all tenants are rebuilt, since
+// they may inherit the changed list.
+func (s *profanityFilters) changed(lang string, f wordfilter.ProfanityFilter) {
+	for k, c := range *s.filters {
+		if k.lang != lang || c.filter == f {
+			continue
+		}
+
+		if err := c.filter.Reload(); err != nil {
+			log.Errorf("reload %s %s %s: %v", k.tenant, lang, k.lists, err)
+		}
+	}
+}
+
+// reloadAll rebuilds all loaded filters.
+func (s *profanityFilters) reloadAll() {
+	for k, c := range *s.filters {
+		if err := c.filter.Reload(); err != nil {
+			log.Errorf("reload %s %s %s: %v", k.tenant, k.lang, k.lists, err)
+		}
+	}
+}
+
+// validListName reports whether name is a valid list name: letters, digits,
+// - and _.
+func validListName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queryFilter returns the filter of the lists in the comma separated lists
+// parameter, or of the default list.
+func queryFilter(r *http.Request, lang string) (wordfilter.ProfanityFilter, bool) {
+	return listsFilter(r, lang, splitList(r.FormValue("lists")))
+}
+
+// listsFilter returns the filter of lists, or of the default list.
+func listsFilter(r *http.Request, lang string, lists []string) (wordfilter.ProfanityFilter, bool) {
+	for _, name := range lists {
+		if !validListName(name) {
+			return nil, false
+		}
+	}
+
+	return filters.get(tenantOf(r), lang, lists...), true
+}
+
+// pathFilter returns the filter of the list named in the path, or of the
+// default list.
+func pathFilter(r *http.Request, lang string) (wordfilter.ProfanityFilter, bool) {
+	name, ok := mux.Vars(r)["name"]
+
+	if !ok {
+		return filters.get(tenantOf(r), lang), true
+	}
+
+	if !validListName(name) {
+		return nil, false
+	}
+
+	return filters.get(tenantOf(r), lang, name), true
 }
 
 func jsonError(w http.ResponseWriter, error string, code int) {
@@ -57,20 +301,104 @@ func jsonError(w http.ResponseWriter, error string, code int) {
 	fmt.Fprintf(w, `{error: "%s"}`, error)
 }
 
+// listError writes the error of a list update. Invalid patterns are the
+// client's fault.
+func listError(w http.ResponseWriter, r *http.Request, err error) {
+	if perr, ok := err.(*wordfilter.PatternError); ok {
+		writeError(w, r, perr.Error(), 400)
+		return
+	}
+
+	log.Errorln(err)
+	writeError(w, r, "Internal error", 500)
+}
+
 type errorResponse struct {
-	Error string `json:error`
-	Code  int    `json:code`
+	Error string `json:"error"`
+	Code  int    `json:"code"`
 }
 
 type sanitizeResponse struct {
 	Text string `json:"text"`
 }
 
+type matchResponse struct {
+	Start      int               `json:"start"`
+	End        int               `json:"end"`
+	RuneStart  int               `json:"rune_start"`
+	RuneEnd    int               `json:"rune_end"`
+	Text       string            `json:"text"`
+	Entry      string            `json:"entry"`
+	Severity   wordlist.Severity `json:"severity,omitempty"`
+	Categories []string          `json:"categories,omitempty"`
+}
+
+type checkResponse struct {
+	Profane bool             `json:"profane"`
+	Matches []*matchResponse `json:"matches"`
+}
+
+type containsResponse struct {
+	Profane bool `json:"profane"`
+}
+
 type blacklistResponse struct {
 	Blacklist []string `json:"blacklist"`
 	Total     int      `json:"total"`
 }
 
+type allowlistResponse struct {
+	Allowlist []string `json:"allowlist"`
+	Total     int      `json:"total"`
+}
+
+// parseOptions reads the mask, min_severity and categories parameters.
+func parseOptions(r *http.Request) (*wordfilter.Options, string) {
+	return newOptions(r.FormValue("mask"), r.FormValue("min_severity"), splitList(r.FormValue("categories")))
+}
+
+// newOptions returns the options of a request, or the error message of an
+// invalid option.
+func newOptions(mask, severity string, categories []string) (*wordfilter.Options, string) {
+	opts := new(wordfilter.Options)
+
+	if mask != "" {
+		m, err := wordfilter.ParseMasker(mask)
+
+		if err != nil {
+			return nil, "Invalid mask"
+		}
+
+		opts.Mask = m
+	}
+
+	if severity != "" {
+		s, err := wordlist.ParseSeverity(severity)
+
+		if err != nil {
+			return nil, "Invalid min_severity"
+		}
+
+		opts.MinSeverity = s
+	}
+
+	opts.Categories = categories
+	return opts, ""
+}
+
+// splitList splits a comma separated list.
+func splitList(v string) []string {
+	var res []string
+
+	for _, c := range strings.Split(v, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			res = append(res, c)
+		}
+	}
+
+	return res
+}
+
 func sanitizeHandle(w http.ResponseWriter, r *http.Request) {
 	lang := r.FormValue("lang")
 	if lang == "" {
@@ -78,98 +406,268 @@ func sanitizeHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts, msg := parseOptions(r)
+
+	if opts == nil {
+		jsonError(w, msg, 400)
+		return
+	}
+
+	filter, ok := queryFilter(r, lang)
+
+	if !ok {
+		jsonError(w, "Invalid lists", 400)
+		return
+	}
+
 	text := r.FormValue("text")
-	sanitized := filters.get(lang).Sanitize(text)
+	sanitized := filter.SanitizeWith(text, opts)
 	log.Printf("lang: %s, text: %s, sanitized: %s", lang, text, sanitized)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(w).Encode(&sanitizeResponse{Text: sanitized})
 }
 
-func updateBlacklistHandle(w http.ResponseWriter, r *http.Request) {
-	log.Printf("update blacklist")
+func checkHandle(w http.ResponseWriter, r *http.Request) {
 	lang := r.FormValue("lang")
 	if lang == "" {
 		jsonError(w, "Invalid lang", 400)
 		return
 	}
-	blacklist, ok := r.Form["blacklist"]
 
-	if !ok || len(blacklist) == 0 {
-		jsonError(w, "Expected `blacklist` key", 400)
+	opts, msg := parseOptions(r)
+
+	if opts == nil {
+		jsonError(w, msg, 400)
 		return
 	}
 
-	switch r.Method {
-	case "PUT":
-		filters.get(lang).Set(blacklist)
-		w.WriteHeader(200)
-	case "POST":
-		filters.get(lang).Replace(blacklist)
-		w.WriteHeader(201)
-	default:
-		panic("should not reach")
+	filter, ok := queryFilter(r, lang)
+
+	if !ok {
+		jsonError(w, "Invalid lists", 400)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(newCheckResponse(filter, filter.MatchesWith(r.FormValue("text"), opts)))
 }
 
-func removeBlacklistHandle(w http.ResponseWriter, r *http.Request) {
-	lang := r.FormValue("lang")
-	if lang == "" {
-		jsonError(w, "Invalid lang", 400)
-		return
+func newCheckResponse(filter wordfilter.ProfanityFilter, matches []wordfilter.Match) *checkResponse {
+	resp := &checkResponse{
+		Profane: len(matches) > 0,
+		Matches: make([]*matchResponse, len(matches)),
 	}
-	blacklist, ok := r.Form["blacklist"]
 
-	if !ok || len(blacklist) == 0 {
-		jsonError(w, "Expected `blacklist` key", 400)
-		return
+	for i, m := range matches {
+		e := filter.Entry(m.Entry)
+		resp.Matches[i] = &matchResponse{
+			Start:      m.Start,
+			End:        m.End,
+			RuneStart:  m.RuneStart,
+			RuneEnd:    m.RuneEnd,
+			Text:       m.Text,
+			Entry:      m.Entry,
+			Severity:   e.Severity,
+			Categories: e.Categories,
+		}
 	}
 
-	filters.get(lang).Delete(blacklist)
-	w.WriteHeader(200)
+	return resp
 }
 
-func getBlacklistHandle(w http.ResponseWriter, r *http.Request) {
-	log.Printf("GET blacklist")
+func containsHandle(w http.ResponseWriter, r *http.Request) {
 	lang := r.FormValue("lang")
 	if lang == "" {
 		jsonError(w, "Invalid lang", 400)
 		return
 	}
 
-	count, err := strconv.Atoi(r.FormValue("count"))
-	if err != nil {
-		count = 20
-	}
+	opts, msg := parseOptions(r)
 
-	offset, err := strconv.Atoi(r.FormValue("offset"))
-	if err != nil {
-		offset = 0
+	if opts == nil {
+		jsonError(w, msg, 400)
+		return
 	}
 
-	log.Printf("lang: %s, count: %d, offset: %d", lang, count, offset)
-	// TODO: handle err
-	filter := filters.get(lang)
-	list, err := filter.Get(count, offset)
-	if err != nil {
-		log.Errorln(err)
-	}
-	cnt, err := filter.Count()
-	if err != nil {
-		log.Errorln(err)
+	filter, ok := queryFilter(r, lang)
+
+	if !ok {
+		jsonError(w, "Invalid lists", 400)
+		return
 	}
 
+	profane := filter.ContainsWith(r.FormValue("text"), opts)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(&containsResponse{Profane: profane})
+}
+
+// blacklistOf returns the blacklist of f.
+func blacklistOf(f wordfilter.ProfanityFilter) wordlist.Wordlist {
+	return f
+}
+
+// allowlistOf returns the allowlist of f.
+func allowlistOf(f wordfilter.ProfanityFilter) wordlist.Wordlist {
+	return f.Allowlist()
+}
+
+// updateListHandle adds the words in the form value key to the list. PUT adds
+// to the list and POST replaces it.
+func updateListHandle(key string, listOf func(wordfilter.ProfanityFilter) wordlist.Wordlist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("update %s", key)
+		lang := r.FormValue("lang")
+		if lang == "" {
+			jsonError(w, "Invalid lang", 400)
+			return
+		}
+		words, ok := r.Form[key]
+
+		if !ok || len(words) == 0 {
+			jsonError(w, fmt.Sprintf("Expected `%s` key", key), 400)
+			return
+		}
+
+		severity := wordlist.Unrated
+
+		if v := r.FormValue("severity"); v != "" {
+			s, err := wordlist.ParseSeverity(v)
+
+			if err != nil {
+				jsonError(w, "Invalid severity", 400)
+				return
+			}
+
+			severity = s
+		}
+
+		entries := make([]wordlist.Entry, len(words))
+		categories := splitList(r.FormValue("categories"))
+
+		for i, word := range words {
+			entries[i] = wordlist.Entry{Word: word, Severity: severity, Categories: categories}
+		}
+
+		filter, ok := pathFilter(r, lang)
+
+		if !ok {
+			jsonError(w, "Invalid list", 400)
+			return
+		}
+
+		list := listOf(filter)
+		var err error
+		var code int
+
+		switch r.Method {
+		case "PUT":
+			err = list.SetEntries(entries)
+			code = 200
+		case "POST":
+			err = list.ReplaceEntries(entries)
+			code = 201
+		default:
+			panic("should not reach")
+		}
 
-	if list == nil {
-		list = make([]string, 0)
-		cnt = 0
+		if err != nil {
+			listError(w, r, err)
+			return
+		}
+
+		filters.changed(lang, filter)
+		reloads.publish(lang)
+		w.WriteHeader(code)
 	}
+}
+
+// removeListHandle removes the words in the form value key from the list.
+func removeListHandle(key string, listOf func(wordfilter.ProfanityFilter) wordlist.Wordlist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lang := r.FormValue("lang")
+		if lang == "" {
+			jsonError(w, "Invalid lang", 400)
+			return
+		}
+		words, ok := r.Form[key]
+
+		if !ok || len(words) == 0 {
+			jsonError(w, fmt.Sprintf("Expected `%s` key", key), 400)
+			return
+		}
+
+		filter, ok := pathFilter(r, lang)
+
+		if !ok {
+			jsonError(w, "Invalid list", 400)
+			return
+		}
+
+		if err := listOf(filter).Delete(words); err != nil {
+			listError(w, r, err)
+			return
+		}
+
+		filters.changed(lang, filter)
+		reloads.publish(lang)
+		w.WriteHeader(200)
+	}
+}
 
-	resp := &blacklistResponse{
-		Blacklist: list,
-		Total:     cnt,
+// getListHandle returns a page of the list, encoded by response.
+func getListHandle(listOf func(wordfilter.ProfanityFilter) wordlist.Wordlist, response func(list []string, total int) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lang := r.FormValue("lang")
+		if lang == "" {
+			jsonError(w, "Invalid lang", 400)
+			return
+		}
+
+		count, err := strconv.Atoi(r.FormValue("count"))
+		if err != nil {
+			count = 20
+		}
+
+		offset, err := strconv.Atoi(r.FormValue("offset"))
+		if err != nil {
+			offset = 0
+		}
+
+		log.Printf("lang: %s, count: %d, offset: %d", lang, count, offset)
+		f, ok := pathFilter(r, lang)
+
+		if !ok {
+			jsonError(w, "Invalid list", 400)
+			return
+		}
+
+		// TODO: handle err
+		filter := listOf(f)
+		list, err := filter.Get(count, offset)
+		if err != nil {
+			log.Errorln(err)
+		}
+		cnt, err := filter.Count()
+		if err != nil {
+			log.Errorln(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		if list == nil {
+			list = make([]string, 0)
+			cnt = 0
+		}
+
+		json.NewEncoder(w).Encode(response(list, cnt))
 	}
+}
+
+func blacklistResponseOf(list []string, total int) interface{} {
+	return &blacklistResponse{Blacklist: list, Total: total}
+}
 
-	json.NewEncoder(w).Encode(resp)
+func allowlistResponseOf(list []string, total int) interface{} {
+	return &allowlistResponse{Allowlist: list, Total: total}
 }
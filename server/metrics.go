@@ -0,0 +1,341 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/simonz05/profanity/db"
+)
+
+// durationBuckets are the upper bounds in seconds of the buckets of the
+// latency and rebuild histograms.
+var durationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts observations in durationBuckets.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+
+	i := sort.SearchFloat64s(durationBuckets, v)
+
+	if i < len(durationBuckets) {
+		h.counts[i]++
+	}
+
+	h.sum += v
+	h.count++
+}
+
+type requestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+// metricsSet holds the metrics served by /metrics.
+type metricsSet struct {
+	inFlight int64 // accessed atomically
+
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[string]*histogram // by route
+	matches  map[string]uint64     // by lang label
+	rebuilds map[string]*histogram // by lang label
+	entries  map[string]int        // of the global default list by lang
+	// langs are the langs whose filters have held entries. Other langs,
+	// which any client can name, are labeled "other".
+	langs map[string]bool
+}
+
+func newMetricsSet() *metricsSet {
+	return &metricsSet{
+		requests: make(map[requestKey]uint64),
+		latency:  make(map[string]*histogram),
+		matches:  make(map[string]uint64),
+		rebuilds: make(map[string]*histogram),
+		entries:  make(map[string]int),
+		langs:    make(map[string]bool),
+	}
+}
+
+// handler counts the requests of each route and measures their latency.
+// Routes are named by the name of their mux route.
+func (m *metricsSet) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "other"
+		var match mux.RouteMatch
+
+		if router.Match(r, &match) && match.Route.GetName() != "" {
+			route = match.Route.GetName()
+		}
+
+		method := r.Method
+
+		switch method {
+		case "GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH":
+		default:
+			method = "other"
+		}
+
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+
+		sw := &statusWriter{ResponseWriter: w, code: 200}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		d := time.Since(start)
+
+		m.mu.Lock()
+		m.requests[requestKey{route, method, sw.code}]++
+		observe(m.latency, route, d)
+		m.mu.Unlock()
+	})
+}
+
+// observe adds d to the histogram of key. It must be called with m.mu held.
+func observe(hists map[string]*histogram, key string, d time.Duration) {
+	h, ok := hists[key]
+
+	if !ok {
+		h = new(histogram)
+		hists[key] = h
+	}
+
+	h.observe(d.Seconds())
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController flush the response.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// langLabel returns the label of lang. It must be called with m.mu held.
+func (m *metricsSet) langLabel(lang string) string {
+	if m.langs[lang] {
+		return lang
+	}
+
+	return "other"
+}
+
+// filterObserver counts the matches and reloads of the filter of key.
+type filterObserver struct {
+	key filterKey
+}
+
+func (o filterObserver) Matched(n int) {
+	if n == 0 {
+		return
+	}
+
+	metrics.mu.Lock()
+	metrics.matches[metrics.langLabel(o.key.lang)] += uint64(n)
+	metrics.mu.Unlock()
+}
+
+func (o filterObserver) Reloaded(entries int, d time.Duration) {
+	metrics.mu.Lock()
+
+	if entries > 0 {
+		metrics.langs[o.key.lang] = true
+	}
+
+	observe(metrics.rebuilds, metrics.langLabel(o.key.lang), d)
+
+	if o.key.tenant == globalTenant && o.key.lists == defaultList && metrics.langs[o.key.lang] {
+		metrics.entries[o.key.lang] = entries
+	}
+
+	metrics.mu.Unlock()
+}
+
+// metricsHandle writes the metrics in the Prometheus text format.
+func metricsHandle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	mw := &metricWriter{w: bufio.NewWriter(w)}
+	m := metrics
+
+	mw.header("profanity_http_requests_in_flight", "gauge", "Requests being served.")
+	mw.sample("profanity_http_requests_in_flight", nil, float64(atomic.LoadInt64(&m.inFlight)))
+
+	m.mu.Lock()
+
+	mw.header("profanity_http_requests_total", "counter", "Requests by route, method and status code.")
+	requests := make([]requestKey, 0, len(m.requests))
+
+	for k := range m.requests {
+		requests = append(requests, k)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+
+		if a.route != b.route {
+			return a.route < b.route
+		}
+
+		if a.method != b.method {
+			return a.method < b.method
+		}
+
+		return a.code < b.code
+	})
+
+	for _, k := range requests {
+		mw.sample("profanity_http_requests_total", []string{"route", k.route, "method", k.method, "code", strconv.Itoa(k.code)}, float64(m.requests[k]))
+	}
+
+	mw.header("profanity_http_request_duration_seconds", "histogram", "Latency of requests by route.")
+	mw.histograms("profanity_http_request_duration_seconds", "route", m.latency)
+
+	mw.header("profanity_matches_total", "counter", "Blacklisted words found by lang.")
+
+	for _, lang := range sortedKeys(m.matches) {
+		mw.sample("profanity_matches_total", []string{"lang", lang}, float64(m.matches[lang]))
+	}
+
+	mw.header("profanity_filter_rebuild_duration_seconds", "histogram", "Time to load a blacklist into its filter by lang.")
+	mw.histograms("profanity_filter_rebuild_duration_seconds", "lang", m.rebuilds)
+
+	mw.header("profanity_filter_entries", "gauge", "Entries of the global default blacklist by lang.")
+	langs := make([]string, 0, len(m.entries))
+
+	for lang := range m.entries {
+		langs = append(langs, lang)
+	}
+
+	sort.Strings(langs)
+
+	for _, lang := range langs {
+		mw.sample("profanity_filter_entries", []string{"lang", lang}, float64(m.entries[lang]))
+	}
+
+	m.mu.Unlock()
+
+	if pool, ok := dbConn.(interface{ Stats() db.Stats }); ok {
+		stats := pool.Stats()
+		mw.header("profanity_redis_pool_active_connections", "gauge", "Open connections of the Redis pool, idle or in use.")
+		mw.sample("profanity_redis_pool_active_connections", nil, float64(stats.Active))
+		mw.header("profanity_redis_pool_max_idle_connections", "gauge", "Idle connections kept by the Redis pool.")
+		mw.sample("profanity_redis_pool_max_idle_connections", nil, float64(stats.MaxIdle))
+		mw.header("profanity_redis_pool_dials_total", "counter", "Connections opened by the Redis pool.")
+		mw.sample("profanity_redis_pool_dials_total", nil, float64(stats.Dials))
+		mw.header("profanity_redis_pool_dial_errors_total", "counter", "Connections the Redis pool failed to open.")
+		mw.sample("profanity_redis_pool_dial_errors_total", nil, float64(stats.DialErrors))
+	}
+
+	mw.w.Flush()
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// metricWriter writes metrics in the Prometheus text format.
+type metricWriter struct {
+	w *bufio.Writer
+}
+
+func (mw *metricWriter) header(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample with labels, given as pairs of name and value.
+func (mw *metricWriter) sample(name string, labels []string, v float64) {
+	mw.w.WriteString(name)
+
+	if len(labels) > 0 {
+		mw.w.WriteByte('{')
+
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				mw.w.WriteByte(',')
+			}
+
+			fmt.Fprintf(mw.w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+
+		mw.w.WriteByte('}')
+	}
+
+	mw.w.WriteByte(' ')
+	mw.w.WriteString(formatFloat(v))
+	mw.w.WriteByte('\n')
+}
+
+// histograms writes the histograms of hists, labeled by label.
+func (mw *metricWriter) histograms(name, label string, hists map[string]*histogram) {
+	keys := make([]string, 0, len(hists))
+
+	for k := range hists {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := hists[k]
+		var cumulative uint64
+
+		for i, le := range durationBuckets {
+			if h.counts != nil {
+				cumulative += h.counts[i]
+			}
+
+			mw.sample(name+"_bucket", []string{label, k, "le", formatFloat(le)}, float64(cumulative))
+		}
+
+		mw.sample(name+"_bucket", []string{label, k, "le", "+Inf"}, float64(h.count))
+		mw.sample(name+"_sum", []string{label, k}, h.sum)
+		mw.sample(name+"_count", []string{label, k}, float64(h.count))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
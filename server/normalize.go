@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/simonz05/profanity/config"
+	"github.com/simonz05/profanity/wordfilter"
+)
+
+// newNormalizer returns the normalizer configured for lang, or nil if
+// normalization is disabled. Unicode folding runs before leetspeak
+// substitution so that fullwidth digits are substituted as well. If the
+// substitution table of lang cannot be read, the normalizer uses the default
+// table and the error is returned with it.
+func newNormalizer(conf *config.NormalizeConfig, lang string) (wordfilter.Normalizer, error) {
+	var normalizers []wordfilter.Normalizer
+
+	if conf.Invisible || conf.NFKC || conf.Diacritics || conf.Confusables {
+		normalizers = append(normalizers, &wordfilter.UnicodeNormalizer{
+			RemoveInvisible: conf.Invisible,
+			Compatibility:   conf.NFKC,
+			StripDiacritics: conf.Diacritics,
+			Confusables:     conf.Confusables,
+		})
+	}
+
+	var err error
+
+	if conf.Leet {
+		var table map[rune]string
+		table, err = leetTable(conf.LeetDir, lang)
+		normalizers = append(normalizers, wordfilter.NewSubstitutionNormalizer(table))
+	}
+
+	switch len(normalizers) {
+	case 0:
+		return nil, err
+	case 1:
+		return normalizers[0], err
+	default:
+		return wordfilter.Chain(normalizers...), err
+	}
+}
+
+// checkLeetTables reads the substitution tables in the leet directory, so
+// that a table which cannot be read fails at startup rather than when its
+// language is first used.
+func checkLeetTables(conf *config.NormalizeConfig) error {
+	if !conf.Leet || conf.LeetDir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(conf.LeetDir)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+
+		if _, err := newNormalizer(conf, fi.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// leetTable returns the substitution table of lang read from dir. The file is
+// named by the lang, such as en_US, or by its language, such as en. The
+// default table is used if there is no such file, and returned with the error
+// if the file cannot be read.
+func leetTable(dir, lang string) (map[rune]string, error) {
+	if dir == "" {
+		return wordfilter.DefaultLeetTable, nil
+	}
+
+	names := []string{lang}
+
+	if i := strings.IndexAny(lang, "_-"); i > 0 {
+		names = append(names, lang[:i])
+	}
+
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, filepath.Base(name)))
+
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return wordfilter.DefaultLeetTable, fmt.Errorf("leet table %s: %v", lang, err)
+		}
+
+		table, err := wordfilter.ReadSubstitutions(f)
+		f.Close()
+
+		if err != nil {
+			return wordfilter.DefaultLeetTable, fmt.Errorf("leet table %s: %v", f.Name(), err)
+		}
+
+		return table, nil
+	}
+
+	return wordfilter.DefaultLeetTable, nil
+}
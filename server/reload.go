@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/simonz05/profanity/db"
+	"github.com/simonz05/util/log"
+)
+
+// reloadChannel is the redis channel on which blacklist changes are
+// published.
+const reloadChannel = "profanity:wordlist:reload"
+
+// reloadMessage is published when the blacklist of a lang has changed.
+type reloadMessage struct {
+	Lang   string `json:"lang"`
+	Origin string `json:"origin"`
+}
+
+// reloader publishes blacklist changes to other instances and rebuilds the
+// filters changed by other instances. A nil reloader does nothing, which is
+// used for stores which are not shared between instances.
+type reloader struct {
+	conn db.Conn
+	id   string
+}
+
+func newReloader(conn db.Conn) *reloader {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &reloader{
+		conn: conn,
+		id:   hex.EncodeToString(b),
+	}
+}
+
+// publish notifies the other instances that the blacklist of lang changed.
+func (r *reloader) publish(lang string) {
+	if r == nil {
+		return
+	}
+
+	data, err := json.Marshal(&reloadMessage{Lang: lang, Origin: r.id})
+
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+
+	conn := r.conn.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("PUBLISH", reloadChannel, data); err != nil {
+		log.Errorf("publish reload %s: %v", lang, err)
+	}
+}
+
+// subscribe listens for changes published by other instances until the
+// connection fails, and then reconnects.
+func (r *reloader) subscribe() {
+	if r == nil {
+		return
+	}
+
+	for {
+		if err := r.receive(); err != nil {
+			log.Errorf("reload subscription: %v", err)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+func (r *reloader) receive() error {
+	psc := redis.PubSubConn{Conn: r.conn.Get()}
+	defer psc.Close()
+
+	if err := psc.Subscribe(reloadChannel); err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			r.handle(v.Data)
+		case redis.Subscription:
+			// Changes may have been missed while not subscribed.
+			if v.Kind == "subscribe" {
+				filters.reloadAll()
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+func (r *reloader) handle(data []byte) {
+	msg := new(reloadMessage)
+
+	if err := json.Unmarshal(data, msg); err != nil {
+		log.Errorf("invalid reload message %q: %v", data, err)
+		return
+	}
+
+	if msg.Origin == r.id {
+		return
+	}
+
+	log.Printf("reload %s", msg.Lang)
+	filters.reload(msg.Lang)
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/simonz05/profanity/wordfilter"
+	"github.com/simonz05/util/log"
+)
+
+// maxRESPArgs is the largest number of arguments of a RESP command. Their
+// total size is limited to maxBodySize.
+const maxRESPArgs = 1 << 16
+
+// maxLineSize is the longest line of a RESP command, such as an inline
+// command or the header of a bulk string.
+const maxLineSize = 64 << 10
+
+var errProtocol = errors.New("Protocol error")
+
+// serveRESP serves the filters over the Redis protocol, so that clients can
+// use a Redis client to call them:
+//
+//	SANITIZE lang text          sanitized text
+//	CHECK lang text             array of [text, entry, rune start, rune end]
+//	CONTAINS lang text          1 if text is profane, else 0
+//	BLADD lang word [word ...]  add words to the blacklist
+//	BLDEL lang word [word ...]  remove words from the blacklist
+//	BLGET lang [count [offset]] a page of the blacklist
+//	AUTH key                    use the API key for the connection
+//	PING [message], QUIT
+func serveRESP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			return err
+		}
+
+		go newRESPConn(conn).serve()
+	}
+}
+
+// respConn is a client connection of the RESP listener.
+type respConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	key    *apiKey
+	tenant string
+}
+
+func newRESPConn(conn net.Conn) *respConn {
+	return &respConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+func (c *respConn) serve() {
+	defer c.conn.Close()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("resp: panic serving %s: %v", c.conn.RemoteAddr(), err)
+		}
+	}()
+
+	for {
+		args, err := c.readCommand()
+
+		if err != nil {
+			if err != io.EOF {
+				c.writeError("ERR " + err.Error())
+				c.w.Flush()
+			}
+
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := c.do(strings.ToUpper(args[0]), args[1:])
+
+		// Replies of pipelined commands are written together.
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command, which is either an array of bulk strings or
+// an inline command of space separated words.
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+
+	if err != nil || n < 0 || n > maxRESPArgs {
+		return nil, errProtocol
+	}
+
+	args := make([]string, 0, n)
+	total := 0
+
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(line, "$") {
+			return nil, errProtocol
+		}
+
+		size, err := strconv.Atoi(line[1:])
+
+		if err != nil || size < 0 {
+			return nil, errProtocol
+		}
+
+		if total += size; total > maxBodySize {
+			return nil, errProtocol
+		}
+
+		buf := make([]byte, size+2)
+
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+// readLine reads a line of at most maxLineSize bytes.
+func (c *respConn) readLine() (string, error) {
+	var line []byte
+
+	for {
+		b, err := c.r.ReadSlice('\n')
+
+		if len(line)+len(b) > maxLineSize {
+			return "", errProtocol
+		}
+
+		line = append(line, b...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return "", err
+		}
+
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// do runs a command and reports whether the connection should be closed.
+func (c *respConn) do(cmd string, args []string) bool {
+	switch cmd {
+	case "PING":
+		if len(args) > 0 {
+			c.writeBulk(args[0])
+		} else {
+			c.writeSimple("PONG")
+		}
+	case "QUIT":
+		c.writeSimple("OK")
+		return true
+	case "AUTH":
+		if len(args) != 1 {
+			c.writeArity(cmd)
+		} else {
+			c.auth(args[0])
+		}
+	case "SANITIZE", "CHECK", "CONTAINS":
+		if len(args) != 2 {
+			c.writeArity(cmd)
+		} else if filter := c.filter(scopeRead, args[0]); filter != nil {
+			c.text(cmd, filter, args[1])
+		}
+	case "BLADD", "BLDEL":
+		if len(args) < 2 {
+			c.writeArity(cmd)
+		} else if filter := c.filter(scopeAdmin, args[0]); filter != nil {
+			c.update(cmd, filter, args[0], args[1:])
+		}
+	case "BLGET":
+		if len(args) < 1 || len(args) > 3 {
+			c.writeArity(cmd)
+		} else if filter := c.filter(scopeRead, args[0]); filter != nil {
+			c.get(filter, args[1:])
+		}
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown command '%s'", cmd))
+	}
+
+	return false
+}
+
+// auth sets the key and tenant of the connection.
+func (c *respConn) auth(secret string) {
+	if keys != nil {
+		k, ok := keys.bySecret[sha256.Sum256([]byte(secret))]
+
+		if !ok {
+			c.writeError("ERR " + errBadCredentials.Error())
+			return
+		}
+
+		c.key = k
+		c.tenant = k.tenant
+
+		if c.tenant == globalTenant && tenants != nil {
+			c.tenant = tenants.keys[secret]
+		}
+
+		c.writeSimple("OK")
+		return
+	}
+
+	var tenant string
+	ok := false
+
+	if tenants != nil {
+		tenant, ok = tenants.keys[secret]
+	}
+
+	if !ok {
+		c.writeError("ERR " + errBadCredentials.Error())
+		return
+	}
+
+	c.tenant = tenant
+	c.writeSimple("OK")
+}
+
+// filter returns the filter of lang of the connection's tenant, or writes
+// the error and returns nil if the connection may not use scope.
+func (c *respConn) filter(scope, lang string) wordfilter.ProfanityFilter {
+	if keys != nil && c.key == nil {
+		c.writeError("NOAUTH Authentication required")
+		return nil
+	}
+
+	if c.key != nil && !c.key.allows(scope) {
+		c.writeError(fmt.Sprintf("NOPERM Key %s lacks the %s scope", c.key.name, scope))
+		return nil
+	}
+
+	if lang == "" {
+		c.writeError("ERR Invalid lang")
+		return nil
+	}
+
+	return filters.get(c.tenant, lang)
+}
+
+func (c *respConn) text(cmd string, filter wordfilter.ProfanityFilter, text string) {
+	switch cmd {
+	case "SANITIZE":
+		c.writeBulk(filter.Sanitize(text))
+	case "CONTAINS":
+		if filter.Contains(text) {
+			c.writeInt(1)
+		} else {
+			c.writeInt(0)
+		}
+	case "CHECK":
+		matches := filter.Matches(text)
+		c.writeArray(len(matches))
+
+		for _, m := range matches {
+			c.writeArray(4)
+			c.writeBulk(m.Text)
+			c.writeBulk(m.Entry)
+			c.writeInt(m.RuneStart)
+			c.writeInt(m.RuneEnd)
+		}
+	}
+}
+
+func (c *respConn) update(cmd string, filter wordfilter.ProfanityFilter, lang string, words []string) {
+	var err error
+
+	if cmd == "BLADD" {
+		err = filter.Set(words)
+	} else {
+		err = filter.Delete(words)
+	}
+
+	if err != nil {
+		if _, ok := err.(*wordfilter.PatternError); !ok {
+			log.Errorln(err)
+			err = errors.New("Internal error")
+		}
+
+		c.writeError("ERR " + err.Error())
+		return
+	}
+
+	filters.changed(lang, filter)
+	reloads.publish(lang)
+	c.writeInt(len(words))
+}
+
+func (c *respConn) get(filter wordfilter.ProfanityFilter, args []string) {
+	count, offset := 20, 0
+	var err error
+
+	if len(args) > 0 {
+		count, err = strconv.Atoi(args[0])
+	}
+
+	if err == nil && len(args) > 1 {
+		offset, err = strconv.Atoi(args[1])
+	}
+
+	if err != nil {
+		c.writeError("ERR value is not an integer")
+		return
+	}
+
+	words, err := filter.Get(count, offset)
+
+	if err != nil {
+		log.Errorln(err)
+		c.writeError("ERR Internal error")
+		return
+	}
+
+	c.writeArray(len(words))
+
+	for _, w := range words {
+		c.writeBulk(w)
+	}
+}
+
+func (c *respConn) writeSimple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) writeError(s string) {
+	c.w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(s) + "\r\n")
+}
+
+func (c *respConn) writeArity(cmd string) {
+	c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func (c *respConn) writeInt(n int) {
+	c.w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (c *respConn) writeBulk(s string) {
+	c.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (c *respConn) writeArray(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
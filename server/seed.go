@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/simonz05/profanity/config"
+	"github.com/simonz05/profanity/types"
+	"github.com/simonz05/profanity/wordfilter"
+	"github.com/simonz05/util/log"
+)
+
+// defaultLangs maps the language codes used to name the bundled word files to
+// the lang used by the API.
+var defaultLangs = map[string]string{
+	"da": "da_DK",
+	"de": "de_DE",
+	"en": "en_US",
+	"es": "es_ES",
+	"fi": "fi_FI",
+	"fr": "fr_FR",
+	"it": "it_IT",
+	"ja": "ja_JP",
+	"nl": "nl_NL",
+	"no": "nb_NO",
+	"pl": "pl_PL",
+	"pt": "pt_BR",
+	"ru": "ru_RU",
+	"sv": "sv_SE",
+	"tr": "tr_TR",
+	"zh": "zh_CN",
+}
+
+// dataLang returns the lang a word file is imported into.
+func dataLang(name string, langs map[string]string) string {
+	if lang, ok := langs[name]; ok {
+		return lang
+	}
+
+	if lang, ok := defaultLangs[name]; ok {
+		return lang
+	}
+
+	return name
+}
+
+// seedFilters imports every word file in conf.Dir into the filter of its
+// lang. By default only empty lists are seeded.
+func seedFilters(conf *config.DataConfig) error {
+	fis, err := ioutil.ReadDir(conf.Dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		name := fi.Name()
+
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		words, err := readWordfile(filepath.Join(conf.Dir, name))
+
+		if err != nil {
+			return err
+		}
+
+		lang := dataLang(name, conf.Langs)
+		filter := filters.get(globalTenant, lang)
+		seeded, err := seedFilter(filter, words, conf.Mode)
+
+		if err != nil {
+			return err
+		}
+
+		if seeded {
+			// Running instances, such as those of an -import, rebuild
+			// their filters of the lang.
+			filters.changed(lang, filter)
+			reloads.publish(lang)
+			log.Printf("seeded %s with %d words from %s", lang, len(words), name)
+		}
+	}
+
+	return nil
+}
+
+func seedFilter(filter wordfilter.ProfanityFilter, words []string, mode types.ImportMode) (bool, error) {
+	if len(words) == 0 {
+		return false, nil
+	}
+
+	switch mode {
+	case types.ImportOverwrite:
+		return true, filter.Replace(words)
+	case types.ImportMerge:
+		return true, filter.Set(words)
+	default:
+		cnt, err := filter.Count()
+
+		if err != nil || cnt > 0 {
+			return false, err
+		}
+
+		return true, filter.Replace(words)
+	}
+}
+
+// readWordfile reads a word file with one entry per line. Blank lines and
+// lines starting with # are skipped.
+func readWordfile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words = append(words, line)
+	}
+
+	return words, scanner.Err()
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"path/filepath"
 
 	"github.com/gorilla/mux"
 	"github.com/simonz05/profanity/config"
@@ -20,42 +22,156 @@ var (
 	router        *mux.Router
 	filters       *profanityFilters
 	dbConn        db.Conn
-	newWordfilter func(list wordlist.Wordlist) *wordfilter.Wordfilter
+	reloads       *reloader
+	tenants       *tenantSet
+	keys          *keySet
+	metrics       *metricsSet
+	newWordlist   func(tenant, name, lang string) wordlist.Wordlist
+	newAllowlist  func(tenant, lang string) wordlist.Wordlist
+	newWordfilter func(lang string, list, allow wordlist.Wordlist) *wordfilter.Wordfilter
 )
 
 func setupServer(conf *config.Config) (err error) {
-	dbConn, err = db.Open(conf.Redis.DSN)
+	switch conf.Data.Mode {
+	case "", types.ImportEmpty, types.ImportMerge, types.ImportOverwrite:
+	default:
+		return fmt.Errorf("invalid data mode %q, expected empty, merge or overwrite", conf.Data.Mode)
+	}
+
+	switch conf.Filter {
+	case "", types.Word, types.Any, types.AhoCorasick:
+	default:
+		return fmt.Errorf("invalid filter %q, expected word, any or ahocorasick", conf.Filter)
+	}
+
+	switch conf.Store {
+	case "", types.Redis, types.Memory, types.File:
+	default:
+		return fmt.Errorf("invalid store %q, expected redis, memory or file", conf.Store)
+	}
+
+	if err = checkLeetTables(&conf.Normalize); err != nil {
+		return
+	}
+
+	switch conf.Store {
+	case types.Memory:
+		newWordlist = func(tenant, name, lang string) wordlist.Wordlist {
+			return wordlist.NewMemoryWordlist()
+		}
+		newAllowlist = func(tenant, lang string) wordlist.Wordlist {
+			return wordlist.NewMemoryWordlist()
+		}
+	case types.File:
+		newWordlist = func(tenant, name, lang string) wordlist.Wordlist {
+			dir := tenantDir(conf.File.Dir, tenant)
+
+			if name != defaultList {
+				dir = filepath.Join(dir, "lists", name)
+			}
+
+			list := wordlist.NewFileWordlist(dir, lang)
+			list.CompactEvery = conf.File.Compact
+			return list
+		}
+		newAllowlist = func(tenant, lang string) wordlist.Wordlist {
+			list := wordlist.NewFileWordlist(filepath.Join(tenantDir(conf.File.Dir, tenant), "allowlist"), lang)
+			list.CompactEvery = conf.File.Compact
+			return list
+		}
+	default:
+		dbConn, err = db.Open(conf.Redis.DSN)
+
+		if err != nil {
+			return
+		}
+
+		newWordlist = func(tenant, name, lang string) wordlist.Wordlist {
+			list := wordlist.NewRedisWordlist(dbConn, lang)
+
+			if name != defaultList {
+				list = wordlist.NewRedisNamedWordlist(dbConn, name, lang)
+			}
+
+			return redisTenant(list, tenant)
+		}
+		newAllowlist = func(tenant, lang string) wordlist.Wordlist {
+			return redisTenant(wordlist.NewRedisAllowlist(dbConn, lang), tenant)
+		}
+
+		reloads = newReloader(dbConn)
+	}
+
+	masker, err := wordfilter.ParseMasker(conf.Mask)
 
 	if err != nil {
 		return
 	}
 
-	newWordfilter = func(list wordlist.Wordlist) *wordfilter.Wordfilter {
-		var replacer wordfilter.Replacer
+	newWordfilter = func(lang string, list, allow wordlist.Wordlist) *wordfilter.Wordfilter {
+		replacer, err := newReplacer(conf.Filter, &conf.Normalize, lang)
 
-		switch conf.Filter {
-		case types.Any:
-			replacer = wordfilter.NewStringReplacer()
-		case types.Word:
-			replacer = wordfilter.NewSetReplacer()
-		default:
-			replacer = wordfilter.NewSetReplacer()
+		// The tables are read at startup, so only a table changed since
+		// fails here.
+		if err != nil {
+			log.Errorln(err)
 		}
 
 		return &wordfilter.Wordfilter{
 			List:     list,
 			Replacer: replacer,
+			Masker:   masker,
+			Allow:    allow,
+		}
+	}
+
+	tenants, err = newTenantSet(conf.Tenant)
+
+	if err != nil {
+		return
+	}
+
+	keys = nil
+
+	if conf.Auth.KeysFile != "" {
+		keys, err = readKeysFile(conf.Auth.KeysFile)
+
+		if err != nil {
+			return
 		}
 	}
 
 	filters = newProfanityFilters()
+	metrics = newMetricsSet()
 
 	// HTTP endpoints
 	router = mux.NewRouter()
-	router.HandleFunc("/v1/profanity/sanitize/", sanitizeHandle).Methods("GET").Name("sanitize")
-	router.HandleFunc("/v1/profanity/blacklist/", updateBlacklistHandle).Methods("POST", "PUT").Name("blacklist")
-	router.HandleFunc("/v1/profanity/blacklist/remove/", removeBlacklistHandle).Methods("POST", "PUT").Name("blacklist")
-	router.HandleFunc("/v1/profanity/blacklist/", getBlacklistHandle).Methods("GET").Name("blacklist")
+	router.HandleFunc("/v1/profanity/sanitize/", authorize(scopeRead, sanitizeHandle)).Methods("GET").Name("sanitize")
+	router.HandleFunc("/v1/profanity/check/", authorize(scopeRead, checkHandle)).Methods("GET").Name("check")
+	router.HandleFunc("/v1/profanity/contains/", authorize(scopeRead, containsHandle)).Methods("GET").Name("contains")
+	router.HandleFunc("/v1/profanity/blacklist/", authorize(scopeAdmin, updateListHandle("blacklist", blacklistOf))).Methods("POST", "PUT").Name("blacklist")
+	router.HandleFunc("/v1/profanity/blacklist/remove/", authorize(scopeAdmin, removeListHandle("blacklist", blacklistOf))).Methods("POST", "PUT").Name("blacklist")
+	router.HandleFunc("/v1/profanity/blacklist/", authorize(scopeRead, getListHandle(blacklistOf, blacklistResponseOf))).Methods("GET").Name("blacklist")
+	router.HandleFunc("/v1/profanity/lists/{name}/", authorize(scopeAdmin, updateListHandle("blacklist", blacklistOf))).Methods("POST", "PUT").Name("lists")
+	router.HandleFunc("/v1/profanity/lists/{name}/remove/", authorize(scopeAdmin, removeListHandle("blacklist", blacklistOf))).Methods("POST", "PUT").Name("lists")
+	router.HandleFunc("/v1/profanity/lists/{name}/", authorize(scopeRead, getListHandle(blacklistOf, blacklistResponseOf))).Methods("GET").Name("lists")
+	router.HandleFunc("/v1/profanity/allowlist/", authorize(scopeAdmin, updateListHandle("allowlist", allowlistOf))).Methods("POST", "PUT").Name("allowlist")
+	router.HandleFunc("/v1/profanity/allowlist/remove/", authorize(scopeAdmin, removeListHandle("allowlist", allowlistOf))).Methods("POST", "PUT").Name("allowlist")
+	router.HandleFunc("/v1/profanity/allowlist/", authorize(scopeRead, getListHandle(allowlistOf, allowlistResponseOf))).Methods("GET").Name("allowlist")
+	router.HandleFunc("/v2/profanity/sanitize/", authorize(scopeRead, textHandle(sanitizeV2, mediaJSON, mediaText))).Methods("POST").Name("v2_sanitize")
+	router.HandleFunc("/v2/profanity/sanitize/batch/", authorize(scopeRead, batchSanitizeHandle)).Methods("POST").Name("v2_sanitize_batch")
+	router.HandleFunc("/v2/profanity/sanitize/stream/", authorize(scopeRead, sanitizeStreamHandle)).Methods("POST").Name("v2_sanitize_stream")
+	router.HandleFunc("/v2/profanity/check/", authorize(scopeRead, textHandle(checkV2, mediaJSON))).Methods("POST").Name("v2_check")
+	router.HandleFunc("/v2/profanity/contains/", authorize(scopeRead, textHandle(containsV2, mediaJSON))).Methods("POST").Name("v2_contains")
+	router.HandleFunc("/v2/profanity/blacklist/", authorize(scopeAdmin, updateListV2Handle(blacklistOf))).Methods("POST", "PUT").Name("v2_blacklist")
+	router.HandleFunc("/v2/profanity/blacklist/remove/", authorize(scopeAdmin, removeListV2Handle(blacklistOf))).Methods("POST").Name("v2_blacklist")
+	router.HandleFunc("/v2/profanity/blacklist/", authorize(scopeRead, getListV2Handle(blacklistOf))).Methods("GET").Name("v2_blacklist")
+	router.HandleFunc("/v2/profanity/lists/{name}/", authorize(scopeAdmin, updateListV2Handle(blacklistOf))).Methods("POST", "PUT").Name("v2_lists")
+	router.HandleFunc("/v2/profanity/lists/{name}/remove/", authorize(scopeAdmin, removeListV2Handle(blacklistOf))).Methods("POST").Name("v2_lists")
+	router.HandleFunc("/v2/profanity/lists/{name}/", authorize(scopeRead, getListV2Handle(blacklistOf))).Methods("GET").Name("v2_lists")
+	router.HandleFunc("/v2/profanity/allowlist/", authorize(scopeAdmin, updateListV2Handle(allowlistOf))).Methods("POST", "PUT").Name("v2_allowlist")
+	router.HandleFunc("/v2/profanity/allowlist/remove/", authorize(scopeAdmin, removeListV2Handle(allowlistOf))).Methods("POST").Name("v2_allowlist")
+	router.HandleFunc("/v2/profanity/allowlist/", authorize(scopeRead, getListV2Handle(allowlistOf))).Methods("GET").Name("v2_allowlist")
 	router.StrictSlash(false)
 
 	// global middleware
@@ -68,13 +184,86 @@ func setupServer(conf *config.Config) (err error) {
 		middleware = append(middleware, handler.LogHandler, handler.RecoveryHandler)
 	}
 
+	if len(conf.Tenant) > 0 {
+		middleware = append(middleware, tenants.handler)
+	}
+
+	middleware = append(middleware, metrics.handler)
+
 	wrapped := handler.Use(router, middleware...)
 	http.Handle("/", wrapped)
+
+	// Metrics are scraped without a key and are not counted.
+	http.HandleFunc("/metrics", metricsHandle)
 	return
 }
 
+// newReplacer returns the replacer of the filter type for lang. The error of
+// an unreadable substitution table is returned with the replacer, which uses
+// the default table.
+func newReplacer(filter types.FilterType, conf *config.NormalizeConfig, lang string) (wordfilter.Replacer, error) {
+	var replacer wordfilter.Replacer
+
+	switch filter {
+	case types.Any:
+		replacer = wordfilter.NewStringReplacer()
+	case types.AhoCorasick:
+		replacer = wordfilter.NewAhoCorasickReplacer()
+	case types.Word:
+		replacer = wordfilter.NewSetReplacer()
+	default:
+		replacer = wordfilter.NewSetReplacer()
+	}
+
+	normalizer, err := newNormalizer(conf, lang)
+
+	if normalizer != nil {
+		replacer = wordfilter.NewNormalizedReplacer(replacer, normalizer)
+	}
+
+	word := filter != types.Any && filter != types.AhoCorasick
+	return wordfilter.NewPatternReplacer(replacer, normalizer, word), err
+}
+
+// tenantDir returns the directory of the lists of tenant in dir.
+func tenantDir(dir, tenant string) string {
+	if tenant == globalTenant {
+		return dir
+	}
+
+	return filepath.Join(dir, "tenants", tenant)
+}
+
+// redisTenant returns list of tenant.
+func redisTenant(list *wordlist.RedisWordlist, tenant string) wordlist.Wordlist {
+	if tenant == globalTenant {
+		return list
+	}
+
+	return wordlist.NewRedisTenantWordlist(list, tenant)
+}
+
+// Import seeds the wordlist store from the configured data directory.
+func Import(conf *config.Config) error {
+	if err := setupServer(conf); err != nil {
+		return err
+	}
+
+	return seedFilters(&conf.Data)
+}
+
 func ListenAndServe(conf *config.Config) error {
-	setupServer(conf)
+	if err := setupServer(conf); err != nil {
+		return err
+	}
+
+	if conf.Data.Dir != "" {
+		if err := seedFilters(&conf.Data); err != nil {
+			return err
+		}
+	}
+
+	go reloads.subscribe()
 
 	l, err := net.Listen("tcp", conf.Listen)
 
@@ -84,6 +273,24 @@ func ListenAndServe(conf *config.Config) error {
 
 	log.Printf("Listen on %s", l.Addr())
 
+	if conf.RESP.Listen != "" {
+		rl, err := net.Listen("tcp", conf.RESP.Listen)
+
+		if err != nil {
+			l.Close()
+			return err
+		}
+
+		log.Printf("Listen for RESP on %s", rl.Addr())
+		sig.TrapCloser(rl)
+
+		go func() {
+			if err := serveRESP(rl); err != nil {
+				log.Printf("RESP listener: %v", err)
+			}
+		}()
+	}
+
 	sig.TrapCloser(l)
 	err = http.Serve(l, nil)
 	log.Print("Shutting down ..")
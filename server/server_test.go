@@ -1,17 +1,31 @@
 package server
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/simonz05/profanity/config"
 	"github.com/simonz05/profanity/types"
+	"github.com/simonz05/profanity/wordfilter"
+	"github.com/simonz05/profanity/wordlist"
 	"github.com/simonz05/util/log"
 	"github.com/simonz05/util/math"
 )
@@ -26,13 +40,28 @@ func startServer() {
 	log.Severity = log.LevelError
 	conf := new(config.Config)
 	conf.Filter = types.Any
-	conf.Redis.DSN = ""
+	conf.Store = types.Memory
 
 	setupServer(conf)
 	server = httptest.NewServer(nil)
 	serverAddr = server.Listener.Addr().String()
 }
 
+// useFilter builds the filters of a test with the filter type instead of
+// that of the server. It returns a function which restores the filters.
+func useFilter(filter types.FilterType) func() {
+	prevNew, prevFilters := newWordfilter, filters
+
+	newWordfilter = func(lang string, list, allow wordlist.Wordlist) *wordfilter.Wordfilter {
+		f := prevNew(lang, list, allow)
+		f.Replacer, _ = newReplacer(filter, new(config.NormalizeConfig), lang)
+		return f
+	}
+
+	filters = newProfanityFilters()
+	return func() { newWordfilter, filters = prevNew, prevFilters }
+}
+
 type SanitizeTest struct {
 	in, out string
 }
@@ -155,6 +184,7 @@ func blacklistHttp(t *testing.T, index int, in, out []string, method string) {
 
 func TestSanitize(t *testing.T) {
 	once.Do(startServer)
+	defer useFilter(types.Word)()
 	blacklistHttp(t, 0, []string{"xxxx"}, []string{"xxxx"}, "POST")
 	tests := []*SanitizeTest{
 		{"foo", "foo"},
@@ -164,16 +194,50 @@ func TestSanitize(t *testing.T) {
 	}
 
 	for i, x := range tests {
-		sanitizeHttp(t, i, x.in, x.out)
+		sanitizeHttp(t, i, x.in, x.out, "")
+	}
+}
+
+func TestSanitizeMask(t *testing.T) {
+	once.Do(startServer)
+	defer useFilter(types.Word)()
+	blacklistHttp(t, 0, []string{"xxxx"}, []string{"xxxx"}, "POST")
+	tests := []*SanitizeTest{
+		{"foo xxxx", "foo x**x"},
+		{"foo fxxxx", "foo fxxxx"},
+	}
+
+	for i, x := range tests {
+		sanitizeHttp(t, i, x.in, x.out, "keepends")
+	}
+
+	values := url.Values{
+		"text": {"foo"},
+		"lang": {"en_US"},
+		"mask": {"unknown"},
+	}
+
+	r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/sanitize/?%s", serverAddr, values.Encode()))
+
+	if err != nil {
+		t.Fatalf("error getting: %s", err)
+	}
+
+	if r.StatusCode != 400 {
+		t.Fatalf("expected status code 400, got %d", r.StatusCode)
 	}
 }
 
-func sanitizeHttp(t *testing.T, index int, in, out string) {
+func sanitizeHttp(t *testing.T, index int, in, out string, mask string) {
 	values := url.Values{
 		"text": {in},
 		"lang": {"en_US"},
 	}
 
+	if mask != "" {
+		values.Set("mask", mask)
+	}
+
 	r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/sanitize/?%s", serverAddr, values.Encode()))
 
 	if err != nil {
@@ -199,6 +263,75 @@ func sanitizeHttp(t *testing.T, index int, in, out string) {
 	}
 }
 
+func TestCheck(t *testing.T) {
+	once.Do(startServer)
+	blacklistHttp(t, 0, []string{"xxxx"}, []string{"xxxx"}, "POST")
+
+	values := url.Values{
+		"text": {"foo xxxx"},
+		"lang": {"en_US"},
+	}
+
+	r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/check/?%s", serverAddr, values.Encode()))
+
+	if err != nil {
+		t.Fatalf("error getting: %s", err)
+	}
+
+	res := new(checkResponse)
+
+	if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &checkResponse{
+		Profane: true,
+		Matches: []*matchResponse{{Start: 4, End: 8, RuneStart: 4, RuneEnd: 8, Text: "xxxx", Entry: "xxxx"}},
+	}
+
+	if !reflect.DeepEqual(res, exp) {
+		t.Fatalf("expected %v, got %v", exp, res)
+	}
+}
+
+func TestContains(t *testing.T) {
+	once.Do(startServer)
+	defer useFilter(types.Word)()
+	blacklistHttp(t, 0, []string{"xxxx"}, []string{"xxxx"}, "POST")
+
+	tests := []struct {
+		in  string
+		out bool
+	}{
+		{"foo", false},
+		{"foo xxxx", true},
+		{"foo fxxxx", false},
+	}
+
+	for i, x := range tests {
+		values := url.Values{
+			"text": {x.in},
+			"lang": {"en_US"},
+		}
+
+		r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/contains/?%s", serverAddr, values.Encode()))
+
+		if err != nil {
+			t.Fatalf("error getting: %s", err)
+		}
+
+		res := new(containsResponse)
+
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Profane != x.out {
+			t.Fatalf("#%d: expected %v, got %v", i, x.out, res.Profane)
+		}
+	}
+}
+
 func BenchmarkServer(b *testing.B) {
 	//once.Do(startServer)
 	serverAddr := "localhost:6061"
@@ -242,3 +375,1240 @@ func BenchmarkServer(b *testing.B) {
 		}
 	}
 }
+
+func TestSeed(t *testing.T) {
+	once.Do(startServer)
+
+	dir, err := ioutil.TempDir("", "data")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	conf := &config.DataConfig{
+		Dir:   dir,
+		Langs: map[string]string{"xx": "xx_XX"},
+	}
+
+	// a loaded filter of other lists of the lang is rebuilt
+	combined := filters.get(globalTenant, "xx_XX", "default", "seed")
+
+	tests := []struct {
+		mode  types.ImportMode
+		words string
+		out   []string
+	}{
+		{types.ImportEmpty, "# comment\nb\n\na\n", []string{"a", "b"}},
+		{types.ImportEmpty, "c\n", []string{"a", "b"}},
+		{types.ImportMerge, "c\n", []string{"a", "b", "c"}},
+		{types.ImportOverwrite, "d\n", []string{"d"}},
+	}
+
+	for i, x := range tests {
+		ioutil.WriteFile(filepath.Join(dir, "xx"), []byte(x.words), 0644)
+		conf.Mode = x.mode
+
+		if err := seedFilters(conf); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+
+		if words, _ := filters.get(globalTenant, "xx_XX").Get(10, 0); !reflect.DeepEqual(words, x.out) {
+			t.Fatalf("#%d: expected %v, got %v", i, x.out, words)
+		}
+	}
+
+	if out := combined.Sanitize("d"); out != "*" {
+		t.Fatalf("expected *, got %s", out)
+	}
+
+	if lang := dataLang("en", nil); lang != "en_US" {
+		t.Fatalf("expected en_US, got %s", lang)
+	}
+
+	bad := new(config.Config)
+	bad.Data.Mode = "overwrit"
+
+	if err := setupServer(bad); err == nil {
+		t.Fatal("expected invalid data mode to fail")
+	}
+
+	bad = new(config.Config)
+	bad.Filter = "aho"
+
+	if err := setupServer(bad); err == nil {
+		t.Fatal("expected invalid filter to fail")
+	}
+
+	bad = new(config.Config)
+	bad.Store = "memroy"
+
+	if err := setupServer(bad); err == nil {
+		t.Fatal("expected invalid store to fail")
+	}
+}
+
+func TestLeetTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leet")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "en"), []byte("4 a\n"), 0644)
+	conf := &config.NormalizeConfig{Leet: true, LeetDir: dir}
+
+	if err := checkLeetTables(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := newNormalizer(conf, "en_US"); err != nil || n == nil {
+		t.Fatalf("expected normalizer, got %v", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "de"), []byte("4\n"), 0644)
+	bad := new(config.Config)
+	bad.Normalize = *conf
+
+	if err := setupServer(bad); err == nil {
+		t.Fatal("expected invalid leet table to fail")
+	}
+}
+
+func TestFilterCache(t *testing.T) {
+	once.Do(startServer)
+	prev := filters
+	defer func() { filters = prev }()
+	filters = newProfanityFilters()
+	filters.max = 2
+
+	a := filters.get(globalTenant, "cache_a")
+	a.Replace([]string{"xxxx"})
+	filters.get(globalTenant, "cache_b")
+	filters.get(globalTenant, "cache_a")
+	filters.get(globalTenant, "cache_c")
+
+	if n := len(*filters.filters); n != 2 {
+		t.Fatalf("expected 2 filters, got %d", n)
+	}
+
+	if _, ok := (*filters.filters)[newFilterKey(globalTenant, "cache_b", nil)]; ok {
+		t.Fatal("expected the least recently used filter to be evicted")
+	}
+
+	if _, ok := filters.stores["/list:default:cache_b"]; ok {
+		t.Fatal("expected the empty list of the evicted filter to be closed")
+	}
+
+	// the words of a memory list outlive its filter
+	filters.get(globalTenant, "cache_c")
+	filters.get(globalTenant, "cache_d")
+
+	if out := filters.get(globalTenant, "cache_a").Sanitize("xxxx"); out != "****" {
+		t.Fatalf("expected ****, got %s", out)
+	}
+
+	// the stores of an evicted filter which the added filter shares are
+	// kept
+	filters = newProfanityFilters()
+	filters.max = 2
+	filters.get(globalTenant, "cache_e")
+	filters.get(globalTenant, "cache_f")
+	combined := filters.get(globalTenant, "cache_e", "default", "kids")
+	e := filters.get(globalTenant, "cache_e")
+	e.Replace([]string{"xxxx"})
+	filters.changed("cache_e", e)
+
+	if out := combined.Sanitize("xxxx"); out != "****" {
+		t.Fatalf("expected ****, got %s", out)
+	}
+
+	if _, ok := filters.stores["/allowlist:cache_e"]; !ok {
+		t.Fatal("expected the allowlist of cache_e to be kept")
+	}
+
+	// the unused stores of an evicted filter are closed
+	prevNew := newWordlist
+	defer func() { newWordlist = prevNew }()
+	var closed []string
+
+	newWordlist = func(tenant, name, lang string) wordlist.Wordlist {
+		return &closingWordlist{wordlist.NewMemoryWordlist(), func() { closed = append(closed, lang) }}
+	}
+
+	filters = newProfanityFilters()
+	filters.max = 1
+	filters.get(globalTenant, "cache_g")
+	filters.get(globalTenant, "cache_h")
+
+	if !reflect.DeepEqual(closed, []string{"cache_g"}) {
+		t.Fatalf("expected the list of cache_g to be closed, got %v", closed)
+	}
+}
+
+// closingWordlist reports when it is closed.
+type closingWordlist struct {
+	*wordlist.MemoryWordlist
+	close func()
+}
+
+func (w *closingWordlist) Close() error {
+	w.close()
+	return nil
+}
+
+func TestReloadMessage(t *testing.T) {
+	once.Do(startServer)
+
+	filter := filters.get(globalTenant, "yy_YY")
+	filter.Replace([]string{"xxxx"})
+	list := filter.(*wordfilter.Wordfilter).List
+	r := newReloader(nil)
+
+	// a change made by another instance
+	list.Replace([]string{"yyyy"})
+	r.handle([]byte(`{"lang":"yy_YY","origin":"other"}`))
+
+	if out := filter.Sanitize("xxxx yyyy"); out != "xxxx ****" {
+		t.Fatalf("expected xxxx ****, got %s", out)
+	}
+
+	// a change made by this instance is already loaded
+	list.Replace([]string{"xxxx"})
+	r.handle([]byte(`{"lang":"yy_YY","origin":"` + r.id + `"}`))
+
+	if out := filter.Sanitize("xxxx yyyy"); out != "xxxx ****" {
+		t.Fatalf("expected xxxx ****, got %s", out)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	once.Do(startServer)
+	lang := "allowlist_test"
+
+	post := func(uri string, words ...string) {
+		values := url.Values{"lang": {lang}, "allowlist": words}
+		r, err := http.PostForm(fmt.Sprintf("http://%s%s", serverAddr, uri), values)
+
+		if err != nil {
+			t.Fatalf("error posting: %s", err)
+		}
+
+		r.Body.Close()
+	}
+
+	contains := func(text string) bool {
+		values := url.Values{"lang": {lang}, "text": {text}}
+		r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/contains/?%s", serverAddr, values.Encode()))
+
+		if err != nil {
+			t.Fatalf("error getting: %s", err)
+		}
+
+		defer r.Body.Close()
+		res := new(containsResponse)
+
+		if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+
+		return res.Profane
+	}
+
+	filters.get(globalTenant, lang).Replace([]string{"xxxx", "yyyy"})
+	post("/v1/profanity/allowlist/", "xxxx")
+
+	if contains("foo xxxx") {
+		t.Fatal("expected allowlisted word to be clean")
+	}
+
+	if !contains("foo yyyy") {
+		t.Fatal("expected blacklisted word to be profane")
+	}
+
+	r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/allowlist/?lang=%s", serverAddr, lang))
+
+	if err != nil {
+		t.Fatalf("error getting: %s", err)
+	}
+
+	res := new(allowlistResponse)
+
+	if err := json.NewDecoder(r.Body).Decode(res); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Body.Close()
+
+	if !reflect.DeepEqual(res.Allowlist, []string{"xxxx"}) || res.Total != 1 {
+		t.Fatalf("expected allowlist [xxxx], got %v (%d)", res.Allowlist, res.Total)
+	}
+
+	post("/v1/profanity/allowlist/remove/", "xxxx")
+
+	if !contains("foo xxxx") {
+		t.Fatal("expected removed allowlist word to be profane")
+	}
+}
+
+// failingWordlist fails to delete words.
+type failingWordlist struct {
+	*wordlist.MemoryWordlist
+}
+
+func (w *failingWordlist) Delete(words []string) error {
+	return errors.New("delete failed")
+}
+
+func TestRemoveListError(t *testing.T) {
+	once.Do(startServer)
+	prevNew, prevFilters := newAllowlist, filters
+	defer func() { newAllowlist, filters = prevNew, prevFilters }()
+
+	newAllowlist = func(tenant, lang string) wordlist.Wordlist {
+		return &failingWordlist{wordlist.NewMemoryWordlist()}
+	}
+
+	filters = newProfanityFilters()
+	values := url.Values{"lang": {"remove_error_test"}, "allowlist": {"xxxx"}}
+	r, err := http.PostForm(fmt.Sprintf("http://%s/v1/profanity/allowlist/remove/", serverAddr), values)
+
+	if err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+
+	r.Body.Close()
+
+	if r.StatusCode != 500 {
+		t.Fatalf("expected status code 500, got %d", r.StatusCode)
+	}
+}
+
+func TestBlacklistPattern(t *testing.T) {
+	once.Do(startServer)
+	blacklistHttp(t, 0, []string{"xxxx", "yy+z*"}, []string{"xxxx", "yy+z*"}, "POST")
+
+	tests := []*SanitizeTest{
+		{"foo yyyzzz", "foo ******"},
+		{"foo yz", "foo yz"},
+	}
+
+	for i, x := range tests {
+		sanitizeHttp(t, i, x.in, x.out, "")
+	}
+
+	values := url.Values{"lang": {"en_US"}, "blacklist": {"xxxx", "yy[z"}}
+	r, err := http.PostForm(fmt.Sprintf("http://%s/v1/profanity/blacklist/", serverAddr), values)
+
+	if err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode != 400 {
+		t.Fatalf("expected status code 400, got %d", r.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), `invalid pattern "yy[z": missing ]`) {
+		t.Fatalf("unexpected error %q", body)
+	}
+
+	blacklistGet(t, 2, 0, []string{"xxxx", "yy+z*"})
+}
+
+func TestCheckSeverity(t *testing.T) {
+	once.Do(startServer)
+	lang := "severity_test"
+
+	put := func(severity, categories string, words ...string) {
+		values := url.Values{"lang": {lang}, "blacklist": words, "severity": {severity}, "categories": {categories}}
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("http://%s/v1/profanity/blacklist/", serverAddr), strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("error putting: %s", err)
+		}
+
+		r.Body.Close()
+
+		if r.StatusCode != 200 {
+			t.Fatalf("expected status code 200, got %d", r.StatusCode)
+		}
+	}
+
+	check := func(query string) (int, *checkResponse) {
+		values := url.Values{"lang": {lang}, "text": {"xxxx yyyy"}}
+		r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/check/?%s&%s", serverAddr, values.Encode(), query))
+
+		if err != nil {
+			t.Fatalf("error getting: %s", err)
+		}
+
+		defer r.Body.Close()
+		res := new(checkResponse)
+		json.NewDecoder(r.Body).Decode(res)
+		return r.StatusCode, res
+	}
+
+	put("mild", "", "xxxx")
+	put("severe", "slur,violence", "yyyy")
+
+	_, res := check("")
+
+	if len(res.Matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d", len(res.Matches))
+	}
+
+	if m := res.Matches[1]; m.Severity != wordlist.Severe || !reflect.DeepEqual(m.Categories, []string{"slur", "violence"}) {
+		t.Fatalf("unexpected match %+v", m)
+	}
+
+	for _, query := range []string{"min_severity=strong", "categories=slur", "categories=drugs,slur"} {
+		if _, res := check(query); len(res.Matches) != 1 || res.Matches[0].Entry != "yyyy" {
+			t.Fatalf("%s: expected match of yyyy, got %+v", query, res.Matches)
+		}
+	}
+
+	if code, _ := check("min_severity=awful"); code != 400 {
+		t.Fatalf("expected status code 400, got %d", code)
+	}
+}
+
+func TestNamedLists(t *testing.T) {
+	once.Do(startServer)
+	lang := "lists_test"
+
+	update := func(method, name string, words ...string) int {
+		values := url.Values{"lang": {lang}, "blacklist": words}
+		uri := fmt.Sprintf("http://%s/v1/profanity/lists/%s/", serverAddr, name)
+		req, _ := http.NewRequest(method, uri, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("error updating: %s", err)
+		}
+
+		r.Body.Close()
+		return r.StatusCode
+	}
+
+	sanitize := func(lists, text string) (int, string) {
+		values := url.Values{"lang": {lang}, "text": {text}, "lists": {lists}}
+		r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/sanitize/?%s", serverAddr, values.Encode()))
+
+		if err != nil {
+			t.Fatalf("error getting: %s", err)
+		}
+
+		defer r.Body.Close()
+		res := new(sanitizeResponse)
+		json.NewDecoder(r.Body).Decode(res)
+		return r.StatusCode, res.Text
+	}
+
+	update("POST", "baseline", "xxxx")
+	update("POST", "kids", "yyyy")
+	filters.get(globalTenant, lang).Replace([]string{"zzzz"})
+
+	tests := []struct {
+		lists, out string
+	}{
+		{"", "xxxx yyyy ****"},
+		{"baseline", "**** yyyy zzzz"},
+		{"kids", "xxxx **** zzzz"},
+		{"baseline,kids", "**** **** zzzz"},
+		{"kids,baseline,default", "**** **** ****"},
+	}
+
+	for i, x := range tests {
+		if _, out := sanitize(x.lists, "xxxx yyyy zzzz"); out != x.out {
+			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
+		}
+	}
+
+	// changing a list rebuilds the cached combinations
+	update("PUT", "kids", "wwww")
+
+	if _, out := sanitize("kids,baseline", "wwww xxxx"); out != "**** ****" {
+		t.Fatalf("expected combined lists to be rebuilt, got %s", out)
+	}
+
+	r, err := http.Get(fmt.Sprintf("http://%s/v1/profanity/lists/kids/?lang=%s", serverAddr, lang))
+
+	if err != nil {
+		t.Fatalf("error getting: %s", err)
+	}
+
+	res := new(blacklistResponse)
+	json.NewDecoder(r.Body).Decode(res)
+	r.Body.Close()
+
+	if !reflect.DeepEqual(res.Blacklist, []string{"wwww", "yyyy"}) {
+		t.Fatalf("expected [wwww yyyy], got %v", res.Blacklist)
+	}
+
+	if code, _ := sanitize("kids,no list", "x"); code != 400 {
+		t.Fatalf("expected status code 400, got %d", code)
+	}
+
+	if code := update("PUT", "no%20list", "x"); code != 400 {
+		t.Fatalf("expected status code 400, got %d", code)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	once.Do(startServer)
+	lang := "tenant_test"
+
+	ts, err := newTenantSet(map[string]config.TenantConfig{
+		"team-a": {Keys: []string{"key-a"}},
+		"team-b": {Keys: []string{"key-b"}, Inherit: true},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := tenants
+	tenants = ts
+	defer func() { tenants = prev }()
+	srv := httptest.NewServer(ts.handler(router))
+	defer srv.Close()
+
+	do := func(method, key, path string, values url.Values) *http.Response {
+		values.Set("lang", lang)
+		req, _ := http.NewRequest(method, srv.URL+path+"?"+values.Encode(), nil)
+
+		if key != "" {
+			req.Header.Set(tenantHeader, key)
+		}
+
+		r, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("error requesting: %s", err)
+		}
+
+		return r
+	}
+
+	sanitize := func(key string) string {
+		r := do("GET", key, "/v1/profanity/sanitize/", url.Values{"text": {"xxxx yyyy zzzz"}})
+		defer r.Body.Close()
+		res := new(sanitizeResponse)
+		json.NewDecoder(r.Body).Decode(res)
+		return res.Text
+	}
+
+	do("POST", "", "/v1/profanity/blacklist/", url.Values{"blacklist": {"xxxx"}}).Body.Close()
+	do("POST", "key-a", "/v1/profanity/blacklist/", url.Values{"blacklist": {"yyyy"}}).Body.Close()
+	do("POST", "key-b", "/v1/profanity/blacklist/", url.Values{"blacklist": {"zzzz"}}).Body.Close()
+
+	tests := []struct {
+		key, out string
+	}{
+		{"", "**** yyyy zzzz"},
+		{"key-a", "xxxx **** zzzz"},
+		{"key-b", "**** yyyy ****"},
+	}
+
+	for i, x := range tests {
+		if out := sanitize(x.key); out != x.out {
+			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
+		}
+	}
+
+	// changes to the global list are inherited
+	do("PUT", "", "/v1/profanity/blacklist/", url.Values{"blacklist": {"yyyy"}}).Body.Close()
+
+	if out := sanitize("key-b"); out != "**** **** ****" {
+		t.Fatalf("expected inherited change, got %s", out)
+	}
+
+	r := do("GET", "key-b", "/v1/profanity/blacklist/", url.Values{})
+	res := new(blacklistResponse)
+	json.NewDecoder(r.Body).Decode(res)
+	r.Body.Close()
+
+	if !reflect.DeepEqual(res.Blacklist, []string{"zzzz"}) {
+		t.Fatalf("expected [zzzz], got %v", res.Blacklist)
+	}
+
+	r = do("GET", "key-c", "/v1/profanity/sanitize/", url.Values{})
+	errRes := new(errorResponse)
+	err = json.NewDecoder(r.Body).Decode(errRes)
+	r.Body.Close()
+
+	if r.StatusCode != 401 || err != nil || errRes.Code != 401 {
+		t.Fatalf("expected JSON error with status code 401, got %d %+v (%v)", r.StatusCode, errRes, err)
+	}
+
+	if _, err := newTenantSet(map[string]config.TenantConfig{"a": {Keys: []string{"k"}}, "b": {Keys: []string{"k"}}}); err == nil {
+		t.Fatal("expected error for shared API key")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	once.Do(startServer)
+	lang := "auth_test"
+
+	ks, err := readKeys(strings.NewReader(`
+# name  secret      scopes      tenant
+web     web-secret  read
+ops     ops-secret  read,admin
+team-a  a-secret    admin       team-a
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1500000000, 0)
+	ks.now = func() time.Time { return now }
+	prev := keys
+	keys = ks
+	defer func() { keys = prev }()
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	do := func(method, path string, values url.Values, auth func(*http.Request)) *http.Response {
+		values.Set("lang", lang)
+		req, _ := http.NewRequest(method, srv.URL+path+"?"+values.Encode(), nil)
+		auth(req)
+		r, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("error requesting: %s", err)
+		}
+
+		return r
+	}
+
+	key := func(secret string) func(*http.Request) {
+		return func(req *http.Request) {
+			if secret != "" {
+				req.Header.Set(tenantHeader, secret)
+			}
+		}
+	}
+
+	signed := func(name, secret string, at time.Time) func(*http.Request) {
+		return func(req *http.Request) {
+			timestamp := strconv.FormatInt(at.Unix(), 10)
+			sig := sign(secret, req.Method, req.URL.RequestURI(), timestamp, nil)
+			req.Header.Set("Authorization", "HMAC "+name+":"+hex.EncodeToString(sig))
+			req.Header.Set("X-Timestamp", timestamp)
+		}
+	}
+
+	blacklist := url.Values{"blacklist": {"xxxx"}}
+	sanitize := url.Values{"text": {"xxxx"}}
+
+	tests := []struct {
+		method, path string
+		values       url.Values
+		auth         func(*http.Request)
+		status       int
+	}{
+		{"GET", "/v1/profanity/sanitize/", sanitize, key(""), 401},
+		{"GET", "/v1/profanity/sanitize/", sanitize, key("wrong"), 401},
+		{"POST", "/v1/profanity/blacklist/", blacklist, key("web-secret"), 403},
+		{"POST", "/v1/profanity/blacklist/", blacklist, key("ops-secret"), 201},
+		{"GET", "/v1/profanity/sanitize/", sanitize, key("web-secret"), 200},
+		{"GET", "/v1/profanity/blacklist/", url.Values{}, key("web-secret"), 200},
+		{"POST", "/v1/profanity/allowlist/", url.Values{"allowlist": {"xx"}}, key("web-secret"), 403},
+		{"GET", "/v1/profanity/sanitize/", sanitize, signed("web", "web-secret", now), 200},
+		{"POST", "/v1/profanity/blacklist/", blacklist, signed("ops", "ops-secret", now.Add(time.Minute)), 201},
+		{"POST", "/v1/profanity/blacklist/", blacklist, signed("web", "web-secret", now), 403},
+		{"GET", "/v1/profanity/sanitize/", sanitize, signed("web", "ops-secret", now), 401},
+		{"GET", "/v1/profanity/sanitize/", sanitize, signed("nobody", "web-secret", now), 401},
+		{"GET", "/v1/profanity/sanitize/", sanitize, signed("web", "web-secret", now.Add(-time.Hour)), 401},
+		{"POST", "/v1/profanity/blacklist/", url.Values{"blacklist": {"yyyy"}}, key("a-secret"), 201},
+	}
+
+	for i, x := range tests {
+		r := do(x.method, x.path, x.values, x.auth)
+		res := new(errorResponse)
+		err := json.NewDecoder(r.Body).Decode(res)
+		r.Body.Close()
+
+		if r.StatusCode != x.status {
+			t.Fatalf("#%d: expected status code %d, got %d", i, x.status, r.StatusCode)
+		}
+
+		if r.StatusCode < 400 {
+			continue
+		}
+
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			t.Fatalf("#%d: expected JSON error, got %s", i, r.Header.Get("Content-Type"))
+		}
+
+		if err != nil || res.Error == "" || res.Code != x.status {
+			t.Fatalf("#%d: expected JSON error body, got %+v (%v)", i, res, err)
+		}
+	}
+
+	// the key of team-a changes the lists of its tenant only
+	r := do("GET", "/v1/profanity/blacklist/", url.Values{}, key("ops-secret"))
+	res := new(blacklistResponse)
+	json.NewDecoder(r.Body).Decode(res)
+	r.Body.Close()
+
+	if !reflect.DeepEqual(res.Blacklist, []string{"xxxx"}) {
+		t.Fatalf("expected [xxxx], got %v", res.Blacklist)
+	}
+
+	for _, in := range []string{"k secret write", "k secret", "k s read\nk t read", "k s read bad/tenant"} {
+		if _, err := readKeys(strings.NewReader(in)); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
+
+func TestV2(t *testing.T) {
+	once.Do(startServer)
+	lang := "v2_test"
+
+	do := func(method, path string, body interface{}, header ...string) *http.Response {
+		var r io.Reader
+
+		if s, ok := body.(string); ok {
+			r = strings.NewReader(s)
+		} else if body != nil {
+			data, _ := json.Marshal(body)
+			r = bytes.NewReader(data)
+		}
+
+		req, _ := http.NewRequest(method, server.URL+path, r)
+		req.Header.Set("Content-Type", "application/json")
+
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		res, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("error requesting: %s", err)
+		}
+
+		return res
+	}
+
+	decode := func(r *http.Response, v interface{}) {
+		defer r.Body.Close()
+
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			t.Fatalf("error decoding: %s", err)
+		}
+	}
+
+	entries := []interface{}{"xxxx", map[string]interface{}{"word": "yyyy", "severity": "severe", "categories": []string{"slur"}}}
+	r := do("POST", "/v2/profanity/blacklist/", map[string]interface{}{"lang": lang, "entries": entries})
+	r.Body.Close()
+
+	if r.StatusCode != 201 {
+		t.Fatalf("expected status code 201, got %d", r.StatusCode)
+	}
+
+	sanitized := new(sanitizeResponse)
+	decode(do("POST", "/v2/profanity/sanitize/", &textRequest{Text: "xxxx a yyyy", Lang: lang}), sanitized)
+
+	if sanitized.Text != "**** a ****" {
+		t.Fatalf("expected **** a ****, got %s", sanitized.Text)
+	}
+
+	decode(do("POST", "/v2/profanity/sanitize/", &textRequest{Text: "xxxx a yyyy", Lang: lang, Categories: []string{"slur"}}), sanitized)
+
+	if sanitized.Text != "xxxx a ****" {
+		t.Fatalf("expected xxxx a ****, got %s", sanitized.Text)
+	}
+
+	r = do("POST", "/v2/profanity/sanitize/", &textRequest{Text: "a xxxx", Lang: lang}, "Accept", "text/html;q=0.9, text/*")
+	data, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	if string(data) != "a ****" || !strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected plain text a ****, got %s %q", r.Header.Get("Content-Type"), data)
+	}
+
+	check := new(checkResponse)
+	decode(do("POST", "/v2/profanity/check/", &textRequest{Text: "a yyyy", Lang: lang}), check)
+
+	if !check.Profane || len(check.Matches) != 1 || check.Matches[0].Severity != wordlist.Severe {
+		t.Fatalf("expected severe match, got %+v", check)
+	}
+
+	contains := new(containsResponse)
+	decode(do("POST", "/v2/profanity/contains/", &textRequest{Text: "a b", Lang: lang}), contains)
+
+	if contains.Profane {
+		t.Fatal("expected clean text")
+	}
+
+	r = do("POST", "/v2/profanity/blacklist/remove/", map[string]interface{}{"lang": lang, "entries": []string{"xxxx"}})
+	r.Body.Close()
+	list := new(listResponse)
+	decode(do("GET", "/v2/profanity/blacklist/?lang="+lang, nil), list)
+	exp := []wordlist.Entry{{Word: "yyyy", Severity: wordlist.Severe, Categories: []string{"slur"}}}
+
+	if list.Total != 1 || !reflect.DeepEqual(list.Entries, exp) {
+		t.Fatalf("expected %v, got %+v", exp, list)
+	}
+
+	errorTests := []struct {
+		method, path string
+		body         interface{}
+		header       []string
+		status       int
+		code         string
+	}{
+		{"POST", "/v2/profanity/sanitize/", &textRequest{Text: "x"}, nil, 400, "invalid_request"},
+		{"POST", "/v2/profanity/sanitize/", "{", nil, 400, "invalid_request"},
+		{"POST", "/v2/profanity/sanitize/", "", nil, 400, "invalid_request"},
+		{"POST", "/v2/profanity/sanitize/", &textRequest{Text: "x", Lang: lang, Lists: []string{"a b"}}, nil, 400, "invalid_request"},
+		{"POST", "/v2/profanity/sanitize/", &textRequest{Text: "x", Lang: lang}, []string{"Content-Type", "text/plain"}, 415, "unsupported_media_type"},
+		{"POST", "/v2/profanity/check/", &textRequest{Text: "x", Lang: lang}, []string{"Accept", "text/plain"}, 406, "not_acceptable"},
+		{"POST", "/v2/profanity/sanitize/", &textRequest{Text: strings.Repeat("x", maxBodySize), Lang: lang}, nil, 413, "request_too_large"},
+		{"PUT", "/v2/profanity/blacklist/", map[string]interface{}{"lang": lang, "entries": []string{"fu[ck"}}, nil, 400, "invalid_request"},
+		{"PUT", "/v2/profanity/blacklist/", map[string]interface{}{"lang": lang}, nil, 400, "invalid_request"},
+	}
+
+	for i, x := range errorTests {
+		res := new(v2ErrorResponse)
+		r := do(x.method, x.path, x.body, x.header...)
+		decode(r, res)
+
+		if r.StatusCode != x.status || res.Error.Status != x.status || res.Error.Code != x.code || res.Error.Message == "" {
+			t.Fatalf("#%d: expected %d %s, got %d %+v", i, x.status, x.code, r.StatusCode, res.Error)
+		}
+	}
+
+	// v1 shares the lists
+	r, err := http.Get(server.URL + "/v1/profanity/sanitize/?" + url.Values{"text": {"a yyyy"}, "lang": {lang}}.Encode())
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	decode(r, sanitized)
+
+	if sanitized.Text != "a ****" {
+		t.Fatalf("expected a ****, got %s", sanitized.Text)
+	}
+
+	// removing the last entry empties the filter
+	r = do("POST", "/v2/profanity/blacklist/remove/", map[string]interface{}{"lang": lang, "entries": []string{"yyyy"}})
+	r.Body.Close()
+
+	if r.StatusCode != 200 {
+		t.Fatalf("expected status code 200, got %d", r.StatusCode)
+	}
+
+	decode(do("POST", "/v2/profanity/sanitize/", &textRequest{Text: "a yyyy", Lang: lang}), sanitized)
+
+	if sanitized.Text != "a yyyy" {
+		t.Fatalf("expected a yyyy, got %s", sanitized.Text)
+	}
+}
+
+func TestBatchSanitize(t *testing.T) {
+	once.Do(startServer)
+	lang := "batch_test"
+	r, err := http.PostForm(server.URL+"/v1/profanity/blacklist/?lang="+lang, url.Values{"blacklist": {"xxxx"}})
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	r.Body.Close()
+
+	batch := func(body string) (*http.Response, *batchResponse) {
+		r, err := http.Post(server.URL+"/v2/profanity/sanitize/batch/", "application/json", strings.NewReader(body))
+
+		if err != nil {
+			t.Fatalf("error requesting: %s", err)
+		}
+
+		defer r.Body.Close()
+		res := new(batchResponse)
+		json.NewDecoder(r.Body).Decode(res)
+		return r, res
+	}
+
+	var items []string
+
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf(`{"id": %d, "text": "%d xxxx", "lang": %q}`, i, i, lang))
+	}
+
+	items = append(items, `{"id": "no-lang", "text": "xxxx"}`, `{"id": "bad", "text": "xxxx", "lang": "x", "mask": "nope"}`, `null`)
+	r, res := batch("[" + strings.Join(items, ",") + "]")
+
+	if r.StatusCode != 200 || len(res.Results) != len(items) {
+		t.Fatalf("expected %d results, got %d %d", len(items), r.StatusCode, len(res.Results))
+	}
+
+	for i := 0; i < 100; i++ {
+		x := res.Results[i]
+
+		if string(x.ID) != strconv.Itoa(i) || x.Text != fmt.Sprintf("%d ****", i) || x.Error != nil {
+			t.Fatalf("#%d: unexpected result %s %q %v", i, x.ID, x.Text, x.Error)
+		}
+	}
+
+	for i, id := range []string{`"no-lang"`, `"bad"`, ``} {
+		x := res.Results[100+i]
+
+		if string(x.ID) != id || x.Error == nil || x.Error.Status != 400 {
+			t.Fatalf("#%d: expected error for %s, got %s %v", i, id, x.ID, x.Error)
+		}
+	}
+
+	items = items[:0]
+
+	for i := 0; i <= maxBatchItems; i++ {
+		items = append(items, `{"text": "x", "lang": "x"}`)
+	}
+
+	if r, _ := batch("[" + strings.Join(items, ",") + "]"); r.StatusCode != 413 {
+		t.Fatalf("expected status code 413, got %d", r.StatusCode)
+	}
+
+	if r, _ := batch(`{"text": "x"}`); r.StatusCode != 400 {
+		t.Fatalf("expected status code 400, got %d", r.StatusCode)
+	}
+}
+
+func TestSanitizeStream(t *testing.T) {
+	once.Do(startServer)
+	defer useFilter(types.Word)()
+	lang := "stream_test"
+	r, err := http.PostForm(server.URL+"/v1/profanity/blacklist/?lang="+lang, url.Values{"blacklist": {"xxxx", "yy zz"}})
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	r.Body.Close()
+
+	// a pipe has no length, so the body is sent chunked
+	pr, pw := io.Pipe()
+
+	go func() {
+		for i := 0; i < 20000; i++ {
+			io.WriteString(pw, "a xxxx yy\nzz b ")
+		}
+
+		pw.Close()
+	}()
+
+	r, err = http.Post(server.URL+"/v2/profanity/sanitize/stream/?mask=rune:%23&lang="+lang, "text/plain", pr)
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	data, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	if r.StatusCode != 200 || len(r.TransferEncoding) == 0 || r.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected chunked response, got %d %v", r.StatusCode, r.TransferEncoding)
+	}
+
+	if expected := strings.Repeat("a #### ##### b ", 20000); string(data) != expected {
+		t.Fatal("expected the stream to be sanitized as a whole")
+	}
+
+	r, err = http.Post(server.URL+"/v2/profanity/sanitize/stream/", "text/plain", strings.NewReader("xxxx"))
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	r.Body.Close()
+
+	if r.StatusCode != 400 {
+		t.Fatalf("expected status code 400, got %d", r.StatusCode)
+	}
+}
+
+func TestRESP(t *testing.T) {
+	once.Do(startServer)
+	defer useFilter(types.Word)()
+	lang := "resp_test"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go serveRESP(l)
+
+	conn, err := redis.Dial("tcp", l.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if n, err := redis.Int(conn.Do("BLADD", lang, "xxxx", "yy zz")); err != nil || n != 2 {
+		t.Fatalf("expected 2 words added, got %d %v", n, err)
+	}
+
+	if out, err := redis.String(conn.Do("SANITIZE", lang, "a xxxx yy  zz")); err != nil || out != "a **** ******" {
+		t.Fatalf("expected sanitized text, got %q %v", out, err)
+	}
+
+	if n, err := redis.Int(conn.Do("CONTAINS", lang, "a b")); err != nil || n != 0 {
+		t.Fatalf("expected clean text, got %d %v", n, err)
+	}
+
+	matches, err := redis.Values(conn.Do("CHECK", lang, "ä xxxx"))
+
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected a match, got %v %v", matches, err)
+	}
+
+	var text, entry string
+	var start, end int
+
+	if _, err := redis.Scan(matches[0].([]interface{}), &text, &entry, &start, &end); err != nil {
+		t.Fatal(err)
+	}
+
+	if text != "xxxx" || entry != "xxxx" || start != 2 || end != 6 {
+		t.Fatalf("unexpected match %s %s %d %d", text, entry, start, end)
+	}
+
+	if n, err := redis.Int(conn.Do("BLDEL", lang, "yy zz")); err != nil || n != 1 {
+		t.Fatalf("expected 1 word removed, got %d %v", n, err)
+	}
+
+	if words, err := redis.Strings(conn.Do("BLGET", lang, 10, 0)); err != nil || !reflect.DeepEqual(words, []string{"xxxx"}) {
+		t.Fatalf("expected [xxxx], got %v %v", words, err)
+	}
+
+	// removing the last word empties the filter
+	if n, err := redis.Int(conn.Do("BLDEL", lang, "xxxx")); err != nil || n != 1 {
+		t.Fatalf("expected 1 word removed, got %d %v", n, err)
+	}
+
+	if out, err := redis.String(conn.Do("SANITIZE", lang, "a xxxx")); err != nil || out != "a xxxx" {
+		t.Fatalf("expected unchanged text, got %q %v", out, err)
+	}
+
+	if n, err := redis.Int(conn.Do("BLADD", lang, "xxxx")); err != nil || n != 1 {
+		t.Fatalf("expected 1 word added, got %d %v", n, err)
+	}
+
+	// pipelined commands
+	conn.Send("PING")
+	conn.Send("SANITIZE", lang, "xxxx")
+	conn.Send("NOPE")
+	conn.Flush()
+
+	if s, err := redis.String(conn.Receive()); err != nil || s != "PONG" {
+		t.Fatalf("expected PONG, got %q %v", s, err)
+	}
+
+	if s, err := redis.String(conn.Receive()); err != nil || s != "****" {
+		t.Fatalf("expected ****, got %q %v", s, err)
+	}
+
+	if _, err := conn.Receive(); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("expected unknown command, got %v", err)
+	}
+
+	if _, err := conn.Do("SANITIZE", lang); err == nil {
+		t.Fatal("expected error for missing text")
+	}
+
+	// with a keys file connections must authenticate
+	ks, err := readKeys(strings.NewReader("web web-secret read\nops ops-secret admin team-a"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := keys
+	keys = ks
+	defer func() { keys = prev }()
+
+	tests := []struct {
+		cmd  string
+		args []interface{}
+		err  string
+	}{
+		{"SANITIZE", []interface{}{lang, "xxxx"}, "NOAUTH"},
+		{"AUTH", []interface{}{"wrong"}, "Invalid API key"},
+		{"AUTH", []interface{}{"web-secret"}, ""},
+		{"SANITIZE", []interface{}{lang, "xxxx"}, ""},
+		{"BLADD", []interface{}{lang, "yyyy"}, "NOPERM"},
+		{"AUTH", []interface{}{"ops-secret"}, ""},
+		{"BLADD", []interface{}{lang, "yyyy"}, ""},
+	}
+
+	for i, x := range tests {
+		_, err := conn.Do(x.cmd, x.args...)
+
+		if x.err == "" && err != nil || x.err != "" && (err == nil || !strings.Contains(err.Error(), x.err)) {
+			t.Fatalf("#%d: expected error %q, got %v", i, x.err, err)
+		}
+	}
+
+	// the key of team-a changed the list of its tenant
+	if out, err := redis.String(conn.Do("SANITIZE", lang, "xxxx yyyy")); err != nil || out != "xxxx ****" {
+		t.Fatalf("expected the list of team-a, got %q %v", out, err)
+	}
+}
+
+func TestRESPProtocolErrors(t *testing.T) {
+	once.Do(startServer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go serveRESP(l)
+
+	tests := []string{
+		"*-1\r\n",
+		"*1\r\n$-5\r\n",
+		"*2\r\n$1\r\na\r\n$x\r\n",
+		"*1\r\n$" + strconv.Itoa(maxBodySize+1) + "\r\n",
+		strings.Repeat("x", maxLineSize+1) + "\r\n",
+	}
+
+	for i, x := range tests {
+		conn, err := net.Dial("tcp", l.Addr().String())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.WriteString(conn, x)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+
+		if string(b) != "-ERR Protocol error\r\n" {
+			t.Fatalf("#%d: expected a protocol error, got %q", i, b)
+		}
+	}
+
+	// the listener still serves connections
+	conn, err := redis.Dial("tcp", l.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if s, err := redis.String(conn.Do("PING")); err != nil || s != "PONG" {
+		t.Fatalf("expected PONG, got %q %v", s, err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	once.Do(startServer)
+	lang := "metrics_test"
+	r, err := http.PostForm(server.URL+"/v1/profanity/blacklist/?lang="+lang, url.Values{"blacklist": {"xxxx", "yyyy"}})
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	r.Body.Close()
+
+	for _, path := range []string{
+		"/v1/profanity/sanitize/?text=xxxx+yyyy&lang=" + lang,
+		"/v1/profanity/check/?text=xxxx&lang=" + lang,
+		"/v1/profanity/sanitize/?text=xxxx&lang=metrics_unknown",
+		"/v1/profanity/sanitize/",
+		"/nope",
+	} {
+		r, err := http.Get(server.URL + path)
+
+		if err != nil {
+			t.Fatalf("error requesting: %s", err)
+		}
+
+		r.Body.Close()
+	}
+
+	r, err = http.Post(server.URL+"/v2/profanity/check/", "text/plain", strings.NewReader("x"))
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	r.Body.Close()
+
+	// metrics are served without a key
+	ks, err := readKeys(strings.NewReader("web web-secret read"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := keys
+	keys = ks
+	defer func() { keys = prev }()
+
+	r, err = http.Get(server.URL + "/metrics")
+
+	if err != nil {
+		t.Fatalf("error requesting: %s", err)
+	}
+
+	data, _ := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	out := string(data)
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %s", r.Header.Get("Content-Type"))
+	}
+
+	for _, line := range []string{
+		"# TYPE profanity_http_requests_total counter\n",
+		`profanity_http_requests_total{route="blacklist",method="POST",code="201"} `,
+		`profanity_http_requests_total{route="sanitize",method="GET",code="400"} `,
+		`profanity_http_requests_total{route="other",method="GET",code="404"} `,
+		`profanity_http_requests_total{route="v2_check",method="POST",code="415"} `,
+		`profanity_http_request_duration_seconds_bucket{route="check",le="+Inf"} `,
+		`profanity_http_request_duration_seconds_count{route="check"} `,
+		"profanity_http_requests_in_flight 0\n",
+		`profanity_matches_total{lang="metrics_test"} 3` + "\n",
+		`profanity_filter_rebuild_duration_seconds_count{lang="metrics_test"} `,
+		`profanity_filter_rebuild_duration_seconds_count{lang="other"} `,
+		`profanity_filter_entries{lang="metrics_test"} 2` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expected %q in metrics:\n%s", line, out)
+		}
+	}
+
+	// langs without entries are not labeled, nor is /metrics counted
+	for _, s := range []string{"metrics_unknown", `route="metrics"`} {
+		if strings.Contains(out, s) {
+			t.Fatalf("unexpected %q in metrics:\n%s", s, out)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/simonz05/profanity/config"
+)
+
+// tenantHeader is the request header which holds the API key of a tenant.
+const tenantHeader = "X-Api-Key"
+
+// globalTenant is the tenant of requests without an API key. Its lists are
+// the lists of a service without tenants.
+const globalTenant = ""
+
+// tenantSet resolves the tenant of a request from its API key.
+type tenantSet struct {
+	keys    map[string]string // API key to tenant
+	inherit map[string]bool
+}
+
+func newTenantSet(conf map[string]config.TenantConfig) (*tenantSet, error) {
+	t := &tenantSet{
+		keys:    make(map[string]string),
+		inherit: make(map[string]bool),
+	}
+
+	for name, tc := range conf {
+		if !validListName(name) {
+			return nil, fmt.Errorf("invalid tenant name %q", name)
+		}
+
+		for _, key := range tc.Keys {
+			if other, ok := t.keys[key]; ok {
+				return nil, fmt.Errorf("tenant %s uses the API key of tenant %s", name, other)
+			}
+
+			t.keys[key] = name
+		}
+
+		t.inherit[name] = tc.Inherit
+	}
+
+	return t, nil
+}
+
+type tenantContextKey struct{}
+
+// handler resolves the tenant of each request. Requests without an API key
+// use the global tenant, and requests with an unknown key are rejected unless
+// a keys file is configured, which then authenticates the key.
+func (t *tenantSet) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(tenantHeader)
+
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, ok := t.keys[key]
+
+		if !ok {
+			if keys == nil {
+				authError(w, r, "Invalid API key", 401)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant)))
+	})
+}
+
+// inherits reports whether tenant filters the words of the global lists.
+func (t *tenantSet) inherits(tenant string) bool {
+	return t != nil && tenant != globalTenant && t.inherit[tenant]
+}
+
+// tenantOf returns the tenant of the request.
+func tenantOf(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantContextKey{}).(string)
+	return tenant
+}
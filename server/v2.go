@@ -0,0 +1,524 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/simonz05/profanity/wordfilter"
+	"github.com/simonz05/profanity/wordlist"
+	"github.com/simonz05/util/log"
+)
+
+// maxBodySize is the largest request body the v2 API reads.
+const maxBodySize = 1 << 20
+
+const (
+	mediaJSON = "application/json"
+	mediaText = "text/plain"
+)
+
+// errorCodes are the codes of the v2 errors by HTTP status.
+var errorCodes = map[int]string{
+	400: "invalid_request",
+	401: "unauthorized",
+	403: "forbidden",
+	406: "not_acceptable",
+	413: "request_too_large",
+	415: "unsupported_media_type",
+	500: "internal_error",
+}
+
+type v2ErrorResponse struct {
+	Error v2Error `json:"error"`
+}
+
+type v2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// v2ErrorOf writes an error of the v2 API.
+func v2ErrorOf(w http.ResponseWriter, message string, status int) {
+	code, ok := errorCodes[status]
+
+	if !ok {
+		code = strings.ToLower(strings.Replace(http.StatusText(status), " ", "_", -1))
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&v2ErrorResponse{Error: v2Error{Status: status, Code: code, Message: message}})
+}
+
+// writeError writes an error in the format of the API version of r.
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		v2ErrorOf(w, message, status)
+		return
+	}
+
+	jsonError(w, message, status)
+}
+
+// negotiate returns the media type of offers which the Accept header of r
+// prefers, or "" if it accepts none of them. Of equally preferred types the
+// first offer is used.
+func negotiate(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+
+	if header == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, offer := range offers {
+		for _, part := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+
+			if err != nil || !matchMedia(mediaType, offer) {
+				continue
+			}
+
+			q := 1.0
+
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			if q > bestQ {
+				best, bestQ = offer, q
+			}
+		}
+	}
+
+	return best
+}
+
+// matchMedia reports whether the media range of an Accept header, such as
+// text/* or */*, includes mediaType.
+func matchMedia(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+}
+
+// decodeBody decodes the JSON body of r into v. It writes the error and
+// returns false if the body is not JSON or too large.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != mediaJSON {
+		v2ErrorOf(w, "Expected a JSON body", 415)
+		return false
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		v2ErrorOf(w, "Request body too large", 413)
+	case err == io.EOF:
+		v2ErrorOf(w, "Empty body", 400)
+	default:
+		v2ErrorOf(w, "Invalid JSON: "+err.Error(), 400)
+	}
+
+	return false
+}
+
+// textRequest is the body of the v2 sanitize, check and contains endpoints.
+type textRequest struct {
+	Text        string   `json:"text"`
+	Lang        string   `json:"lang"`
+	Lists       []string `json:"lists,omitempty"`
+	Mask        string   `json:"mask,omitempty"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+	Categories  []string `json:"categories,omitempty"`
+}
+
+// filter returns the filter and options of the request, or the error
+// message of an invalid request.
+func (t *textRequest) filter(r *http.Request) (wordfilter.ProfanityFilter, *wordfilter.Options, string) {
+	if t.Lang == "" {
+		return nil, nil, "Invalid lang"
+	}
+
+	opts, msg := newOptions(t.Mask, t.MinSeverity, t.Categories)
+
+	if opts == nil {
+		return nil, nil, msg
+	}
+
+	filter, ok := listsFilter(r, t.Lang, t.Lists)
+
+	if !ok {
+		return nil, nil, "Invalid lists"
+	}
+
+	return filter, opts, ""
+}
+
+// textHandle decodes a text request and responds with the result of fn in
+// one of the media types offered.
+func textHandle(fn func(filter wordfilter.ProfanityFilter, req *textRequest, opts *wordfilter.Options) interface{}, offers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType := negotiate(r, offers...)
+
+		if mediaType == "" {
+			v2ErrorOf(w, "Can only respond with "+strings.Join(offers, ", "), 406)
+			return
+		}
+
+		req := new(textRequest)
+
+		if !decodeBody(w, r, req) {
+			return
+		}
+
+		filter, opts, msg := req.filter(r)
+
+		if filter == nil {
+			v2ErrorOf(w, msg, 400)
+			return
+		}
+
+		resp := fn(filter, req, opts)
+
+		if mediaType == mediaText {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, resp.(*sanitizeResponse).Text)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func sanitizeV2(filter wordfilter.ProfanityFilter, req *textRequest, opts *wordfilter.Options) interface{} {
+	return &sanitizeResponse{Text: filter.SanitizeWith(req.Text, opts)}
+}
+
+func checkV2(filter wordfilter.ProfanityFilter, req *textRequest, opts *wordfilter.Options) interface{} {
+	return newCheckResponse(filter, filter.MatchesWith(req.Text, opts))
+}
+
+func containsV2(filter wordfilter.ProfanityFilter, req *textRequest, opts *wordfilter.Options) interface{} {
+	return &containsResponse{Profane: filter.ContainsWith(req.Text, opts)}
+}
+
+// listRequest is the body of the v2 list endpoints. Entries are words or
+// objects with a word, severity and categories.
+type listRequest struct {
+	Lang    string           `json:"lang"`
+	Entries []wordlist.Entry `json:"entries"`
+}
+
+type listResponse struct {
+	Entries []wordlist.Entry `json:"entries"`
+	Total   int              `json:"total"`
+}
+
+// updateListV2Handle changes the list by the entries of the body. PUT adds
+// to the list and POST replaces it, as in the v1 API.
+func updateListV2Handle(listOf func(wordfilter.ProfanityFilter) wordlist.Wordlist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := new(listRequest)
+
+		if !decodeBody(w, r, req) {
+			return
+		}
+
+		if req.Lang == "" {
+			v2ErrorOf(w, "Invalid lang", 400)
+			return
+		}
+
+		if len(req.Entries) == 0 {
+			v2ErrorOf(w, "Expected entries", 400)
+			return
+		}
+
+		filter, ok := pathFilter(r, req.Lang)
+
+		if !ok {
+			v2ErrorOf(w, "Invalid list", 400)
+			return
+		}
+
+		list := listOf(filter)
+		var err error
+		code := 200
+
+		if r.Method == "POST" {
+			err = list.ReplaceEntries(req.Entries)
+			code = 201
+		} else {
+			err = list.SetEntries(req.Entries)
+		}
+
+		if err != nil {
+			listError(w, r, err)
+			return
+		}
+
+		filters.changed(req.Lang, filter)
+		reloads.publish(req.Lang)
+		w.WriteHeader(code)
+	}
+}
+
+// removeListV2Handle removes the words of the entries of the body from the
+// list.
+func removeListV2Handle(listOf func(wordfilter.ProfanityFilter) wordlist.Wordlist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := new(listRequest)
+
+		if !decodeBody(w, r, req) {
+			return
+		}
+
+		if req.Lang == "" {
+			v2ErrorOf(w, "Invalid lang", 400)
+			return
+		}
+
+		filter, ok := pathFilter(r, req.Lang)
+
+		if !ok {
+			v2ErrorOf(w, "Invalid list", 400)
+			return
+		}
+
+		words := make([]string, len(req.Entries))
+
+		for i, e := range req.Entries {
+			words[i] = e.Word
+		}
+
+		if err := listOf(filter).Delete(words); err != nil {
+			listError(w, r, err)
+			return
+		}
+
+		filters.changed(req.Lang, filter)
+		reloads.publish(req.Lang)
+		w.WriteHeader(200)
+	}
+}
+
+// getListV2Handle returns a page of the entries of the list. The list is
+// read from the lang, count and offset query parameters.
+func getListV2Handle(listOf func(wordfilter.ProfanityFilter) wordlist.Wordlist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if negotiate(r, mediaJSON) == "" {
+			v2ErrorOf(w, "Can only respond with "+mediaJSON, 406)
+			return
+		}
+
+		lang := r.FormValue("lang")
+
+		if lang == "" {
+			v2ErrorOf(w, "Invalid lang", 400)
+			return
+		}
+
+		count, err := strconv.Atoi(r.FormValue("count"))
+
+		if err != nil || count < 1 {
+			count = 20
+		}
+
+		offset, err := strconv.Atoi(r.FormValue("offset"))
+
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+
+		filter, ok := pathFilter(r, lang)
+
+		if !ok {
+			v2ErrorOf(w, "Invalid list", 400)
+			return
+		}
+
+		entries, err := listOf(filter).Entries()
+
+		if err != nil {
+			log.Errorln(err)
+			v2ErrorOf(w, "Internal error", 500)
+			return
+		}
+
+		resp := &listResponse{Entries: []wordlist.Entry{}, Total: len(entries)}
+
+		if offset < len(entries) {
+			end := offset + count
+
+			if end > len(entries) {
+				end = len(entries)
+			}
+
+			resp.Entries = entries[offset:end]
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// maxBatchItems is the largest number of texts of a batch request.
+const maxBatchItems = 1000
+
+// batchItem is a text of a batch request. The id is any JSON value, which is
+// returned with the result of the item.
+type batchItem struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	textRequest
+}
+
+type batchResult struct {
+	ID    json.RawMessage `json:"id,omitempty"`
+	Text  string          `json:"text"`
+	Error *v2Error        `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []*batchResult `json:"results"`
+}
+
+// batchSanitizeHandle sanitizes an array of texts concurrently. The results
+// are in the order of the texts, and an invalid item fails on its own.
+func batchSanitizeHandle(w http.ResponseWriter, r *http.Request) {
+	if negotiate(r, mediaJSON) == "" {
+		v2ErrorOf(w, "Can only respond with "+mediaJSON, 406)
+		return
+	}
+
+	var items []*batchItem
+
+	if !decodeBody(w, r, &items) {
+		return
+	}
+
+	if len(items) > maxBatchItems {
+		v2ErrorOf(w, fmt.Sprintf("Batch has more than %d items", maxBatchItems), 413)
+		return
+	}
+
+	results := make([]*batchResult, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for n := 0; n < runtime.GOMAXPROCS(0) && n < len(items); n++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				results[i] = sanitizeItem(r, items[i])
+			}
+		}()
+	}
+
+	for i := range items {
+		next <- i
+	}
+
+	close(next)
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(&batchResponse{Results: results})
+}
+
+func sanitizeItem(r *http.Request, item *batchItem) *batchResult {
+	if item == nil {
+		return &batchResult{Error: &v2Error{Status: 400, Code: errorCodes[400], Message: "Invalid item"}}
+	}
+
+	res := &batchResult{ID: item.ID}
+	filter, opts, msg := item.filter(r)
+
+	if filter == nil {
+		res.Error = &v2Error{Status: 400, Code: errorCodes[400], Message: msg}
+		return res
+	}
+
+	res.Text = filter.SanitizeWith(item.Text, opts)
+	return res
+}
+
+// sanitizeStreamHandle sanitizes the text of the body, which may be of any
+// length, and writes the sanitized text as it goes. The lang and options
+// are read from the query.
+func sanitizeStreamHandle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lang := q.Get("lang")
+
+	if lang == "" {
+		v2ErrorOf(w, "Invalid lang", 400)
+		return
+	}
+
+	opts, msg := newOptions(q.Get("mask"), q.Get("min_severity"), splitList(q.Get("categories")))
+
+	if opts == nil {
+		v2ErrorOf(w, msg, 400)
+		return
+	}
+
+	filter, ok := listsFilter(r, lang, splitList(q.Get("lists")))
+
+	if !ok {
+		v2ErrorOf(w, "Invalid lists", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rc := http.NewResponseController(w)
+
+	// HTTP/1 servers stop reading the body once the response is written,
+	// unless the connection is full duplex.
+	if err := rc.EnableFullDuplex(); err != nil && r.ProtoMajor < 2 {
+		log.Errorf("sanitize stream: %v", err)
+	}
+
+	// The status is sent with the first chunk, so a failure to read the
+	// body can only end the response early.
+	if err := filter.SanitizeStream(flushWriter{w: w, rc: rc}, r.Body, opts); err != nil {
+		log.Errorf("sanitize stream: %v", err)
+	}
+}
+
+// flushWriter flushes each write, so the client receives the sanitized text
+// while the body is read.
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+
+	if err == nil {
+		err = w.rc.Flush()
+	}
+
+	return n, err
+}
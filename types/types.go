@@ -3,6 +3,23 @@ package types
 type FilterType string
 
 const (
-	Any  FilterType = "any"
-	Word FilterType = "word"
+	Any         FilterType = "any"
+	Word        FilterType = "word"
+	AhoCorasick FilterType = "ahocorasick"
+)
+
+type StoreType string
+
+const (
+	Redis  StoreType = "redis"
+	Memory StoreType = "memory"
+	File   StoreType = "file"
+)
+
+type ImportMode string
+
+const (
+	ImportEmpty     ImportMode = "empty"
+	ImportMerge     ImportMode = "merge"
+	ImportOverwrite ImportMode = "overwrite"
 )
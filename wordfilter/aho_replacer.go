@@ -0,0 +1,296 @@
+package wordfilter
+
+import (
+	"sync"
+)
+
+// A thread-safe word filter which uses an Aho-Corasick automaton to find
+// blacklisted words anywhere in the text. The text is scanned once
+// regardless of the size of the blacklist. Of overlapping matches the
+// leftmost, and then the longest, is used. StringReplacer instead uses the
+// entry listed first of those starting at the same position, so the two
+// may mask different text: with the blacklist he, hers and his, "ahishers"
+// is masked as "a*******" here and as "a*****rs" by StringReplacer.
+type AhoCorasickReplacer struct {
+	repl   *ahoCorasick
+	replMu sync.RWMutex // repl locker
+}
+
+// Returns a new word filter. The word filter is empty by default.
+func NewAhoCorasickReplacer() *AhoCorasickReplacer {
+	return &AhoCorasickReplacer{
+		repl: makeAhoCorasick(nil),
+	}
+}
+
+// reload wordlist
+func (p *AhoCorasickReplacer) Reload(words []string) error {
+	repl := makeAhoCorasick(words)
+	p.replMu.Lock()
+	p.repl = repl
+	p.replMu.Unlock()
+	return nil
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by ****.
+func (p *AhoCorasickReplacer) Replace(v string) string {
+	return p.ReplaceWith(v, Stars)
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by the mask.
+func (p *AhoCorasickReplacer) ReplaceWith(v string, m Masker) string {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	buf := make(appendSliceWriter, 0, len(v))
+	last := 0
+
+	p.repl.scan(v, func(start, end int, entry string) bool {
+		buf.WriteString(v[last:start])
+		buf.WriteString(m.Mask(v[start:end]))
+		last = end
+		return true
+	})
+
+	buf.WriteString(v[last:])
+	return string(buf)
+}
+
+// Returns the substrings of v which match a word in the blacklist.
+func (p *AhoCorasickReplacer) Matches(v string) []Match {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	var matches []Match
+
+	p.repl.scan(v, func(start, end int, entry string) bool {
+		matches = append(matches, Match{Start: start, End: end, Text: v[start:end], Entry: entry})
+		return true
+	})
+
+	return runeOffsets(v, matches)
+}
+
+// Reports whether v contains a word in the blacklist. It returns at the
+// first match.
+func (p *AhoCorasickReplacer) Contains(v string) bool {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	found := false
+
+	p.repl.scan(v, func(start, end int, entry string) bool {
+		found = true
+		return false
+	})
+
+	return found
+}
+
+// ahoCorasick is a deterministic automaton over the bytes of the lower case
+// keys. Bytes are remapped to a dense index, as in genericReplacer, and the
+// goto and failure functions are folded into a single transition table.
+type ahoCorasick struct {
+	// mapping maps from key bytes to a dense index. Bytes not used by any
+	// key map to tableSize-1.
+	mapping   [256]byte
+	tableSize int
+	// delta is the transition table; the next state of state s on byte c
+	// is delta[s*tableSize+mapping[c]].
+	delta []int32
+	// depth is the length of the key prefix represented by a state.
+	depth []int
+	// out is the index in entries of the longest key which is a suffix of
+	// the state's prefix, or -1.
+	out []int32
+	// shorter is the index of the longest key which is a proper suffix of
+	// each key, or -1, so that every key ending at a state can be found.
+	shorter []int32
+	entries []string
+	keylen  []int
+}
+
+func makeAhoCorasick(words []string) *ahoCorasick {
+	a := new(ahoCorasick)
+	keys := make([]string, 0, len(words))
+	seen := make(map[string]bool, len(words))
+
+	for _, w := range words {
+		key := lower(w)
+
+		if key == "" || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		keys = append(keys, key)
+		a.entries = append(a.entries, w)
+		a.keylen = append(a.keylen, len(key))
+		a.shorter = append(a.shorter, -1)
+	}
+
+	// Find each byte used, then assign them each an index.
+	var used [256]bool
+
+	for _, key := range keys {
+		for j := 0; j < len(key); j++ {
+			used[key[j]] = true
+		}
+	}
+
+	var index byte
+
+	for c, ok := range used {
+		if ok {
+			a.mapping[c] = index
+			index++
+		}
+	}
+
+	a.tableSize = int(index) + 1
+
+	for c, ok := range used {
+		if !ok {
+			a.mapping[c] = index
+		}
+	}
+
+	// Upper case ASCII bytes share the index of the lower case byte.
+	for c := 'A'; c <= 'Z'; c++ {
+		a.mapping[c] = a.mapping[c+'a'-'A']
+	}
+
+	// Build the trie of keys. -1 marks a missing transition.
+	a.newState(0)
+
+	for i, key := range keys {
+		s := 0
+
+		for j := 0; j < len(key); j++ {
+			t := s*a.tableSize + int(a.mapping[key[j]])
+
+			if a.delta[t] < 0 {
+				a.delta[t] = int32(a.newState(j + 1))
+			}
+
+			s = int(a.delta[t])
+		}
+
+		a.out[s] = int32(i)
+	}
+
+	// Compute failure links breadth first and fold them into delta.
+	fail := make([]int32, len(a.depth))
+	queue := make([]int32, 0, len(a.depth))
+
+	for c := 0; c < a.tableSize; c++ {
+		if t := a.delta[c]; t < 0 {
+			a.delta[c] = 0
+		} else {
+			queue = append(queue, t)
+		}
+	}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		f := fail[s]
+
+		if a.out[s] < 0 {
+			a.out[s] = a.out[f]
+		} else {
+			a.shorter[a.out[s]] = a.out[f]
+		}
+
+		for c := 0; c < a.tableSize; c++ {
+			t := int(s)*a.tableSize + c
+			ft := a.delta[int(f)*a.tableSize+c]
+
+			if a.delta[t] < 0 {
+				a.delta[t] = ft
+			} else {
+				fail[a.delta[t]] = ft
+				queue = append(queue, a.delta[t])
+			}
+		}
+	}
+
+	return a
+}
+
+func (a *ahoCorasick) newState(depth int) int {
+	s := len(a.depth)
+	a.depth = append(a.depth, depth)
+	a.out = append(a.out, -1)
+
+	for c := 0; c < a.tableSize; c++ {
+		a.delta = append(a.delta, -1)
+	}
+
+	return s
+}
+
+// each calls fn with the index in entries of each key which occurs in s,
+// which must be lower case. Keys which occur several times, or end where
+// another key ends, are reported for each occurrence.
+func (a *ahoCorasick) each(s string, fn func(i int)) {
+	if len(a.entries) == 0 {
+		return
+	}
+
+	state := 0
+
+	for i := 0; i < len(s); i++ {
+		state = int(a.delta[state*a.tableSize+int(a.mapping[s[i]])])
+
+		for o := a.out[state]; o >= 0; o = a.shorter[o] {
+			fn(int(o))
+		}
+	}
+}
+
+// scan calls fn with the byte offsets and entry of each non-overlapping match
+// in s. Scanning stops when fn returns false.
+func (a *ahoCorasick) scan(s string, fn func(start, end int, entry string) bool) {
+	if len(a.entries) == 0 {
+		return
+	}
+
+	// ASCII case is ignored by the mapping, so only non-ASCII text has to
+	// be lowered.
+	l := s
+	if !isASCII(s) {
+		l = lower(s)
+	}
+
+	state := 0
+	best := -1 // entry of the leftmost-longest match found so far
+	var bestStart, bestEnd int
+
+	for i := 0; i < len(l); i++ {
+		state = int(a.delta[state*a.tableSize+int(a.mapping[l[i]])])
+
+		if o := a.out[state]; o >= 0 {
+			start := i + 1 - a.keylen[o]
+
+			if best < 0 || start < bestStart || start == bestStart && i+1 > bestEnd {
+				best, bestStart, bestEnd = int(o), start, i+1
+			}
+		}
+
+		// The match is final once no key starting at or before it can
+		// still be extended. Scanning restarts after the match.
+		if best >= 0 && i+1-a.depth[state] > bestStart {
+			if !fn(bestStart, bestEnd, a.entries[best]) {
+				return
+			}
+
+			i = bestEnd - 1
+			state = 0
+			best = -1
+		}
+	}
+
+	if best >= 0 {
+		fn(bestStart, bestEnd, a.entries[best])
+	}
+}
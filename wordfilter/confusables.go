@@ -0,0 +1,88 @@
+package wordfilter
+
+// confusables maps letters which are commonly confused with latin letters to
+// the latin letter. It is a subset of the skeleton mapping of Unicode
+// Technical Standard #39 for the cyrillic, greek and latin scripts. Both cases
+// are mapped since the text is lowered after it is normalized.
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a',
+	'А': 'a',
+	'в': 'b',
+	'В': 'b',
+	'Ь': 'b',
+	'с': 'c',
+	'С': 'c',
+	'ԁ': 'd',
+	'е': 'e',
+	'Е': 'e',
+	'ё': 'e',
+	'һ': 'h',
+	'Н': 'h',
+	'і': 'i',
+	'І': 'i',
+	'ј': 'j',
+	'Ј': 'j',
+	'к': 'k',
+	'К': 'k',
+	'ӏ': 'l',
+	'м': 'm',
+	'М': 'm',
+	'о': 'o',
+	'О': 'o',
+	'р': 'p',
+	'Р': 'p',
+	'ԛ': 'q',
+	'ѕ': 's',
+	'Ѕ': 's',
+	'т': 't',
+	'Т': 't',
+	'υ': 'u',
+	'ѵ': 'v',
+	'ԝ': 'w',
+	'х': 'x',
+	'Х': 'x',
+	'у': 'y',
+	'У': 'y',
+	// greek
+	'α': 'a',
+	'Α': 'a',
+	'β': 'b',
+	'Β': 'b',
+	'ε': 'e',
+	'Ε': 'e',
+	'Η': 'h',
+	'ι': 'i',
+	'Ι': 'i',
+	'κ': 'k',
+	'Κ': 'k',
+	'Μ': 'm',
+	'Ν': 'n',
+	'ο': 'o',
+	'Ο': 'o',
+	'ρ': 'p',
+	'Ρ': 'p',
+	'τ': 't',
+	'Τ': 't',
+	'ν': 'v',
+	'χ': 'x',
+	'Χ': 'x',
+	'γ': 'y',
+	'Υ': 'y',
+	'Ζ': 'z',
+	// latin
+	'ı': 'i',
+	'ȷ': 'j',
+	'ſ': 's',
+	'ɑ': 'a',
+	'ɡ': 'g',
+	'ɩ': 'i',
+	'ɪ': 'i',
+	'ʏ': 'y',
+	'ᴄ': 'c',
+	'ᴏ': 'o',
+	'ᴜ': 'u',
+	'ᴠ': 'v',
+	'ᴡ': 'w',
+	'ᴢ': 'z',
+}
@@ -0,0 +1,72 @@
+package wordfilter
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	zwj = '‍' // ZERO WIDTH JOINER
+)
+
+// graphemes returns the number of user-perceived characters in s.
+func graphemes(s string) int {
+	n := 0
+
+	graphemeClusters(s, func(start, end int) {
+		n++
+	})
+
+	return n
+}
+
+// graphemeClusters calls fn with the byte offsets of each user-perceived
+// character in s. It follows the extended grapheme cluster rules of Unicode
+// text segmentation (UAX #29) in simplified form: combining marks, variation
+// selectors, emoji modifiers and Hangul vowel and final jamo extend the
+// previous character, CR LF is one character, a zero width joiner joins the
+// next character, and regional indicators are paired into flags.
+func graphemeClusters(s string, fn func(start, end int)) {
+	start := 0
+	var prev rune
+	regional := false // prev is the first of a pair of regional indicators
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case i == 0:
+			regional = isRegionalIndicator(r)
+		case prev == '\r' && r == '\n':
+		case prev == zwj:
+		case isGraphemeExtend(r):
+		case regional && isRegionalIndicator(r):
+			regional = false
+		default:
+			fn(start, i)
+			start = i
+			regional = isRegionalIndicator(r)
+		}
+
+		prev = r
+		i += size
+	}
+
+	if start < len(s) {
+		fn(start, len(s))
+	}
+}
+
+// isGraphemeExtend reports whether r extends the previous character.
+func isGraphemeExtend(r rune) bool {
+	return unicode.IsMark(r) ||
+		r == zwj ||
+		unicode.Is(unicode.Variation_Selector, r) ||
+		0x1f3fb <= r && r <= 0x1f3ff || // emoji modifiers
+		0x1160 <= r && r <= 0x11ff || // hangul vowel and final jamo
+		0xe0020 <= r && r <= 0xe007f // tags
+}
+
+func isRegionalIndicator(r rune) bool {
+	return 0x1f1e6 <= r && r <= 0x1f1ff
+}
@@ -0,0 +1,143 @@
+package wordfilter
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Masker returns the text drawn in place of a blacklisted word.
+type Masker interface {
+	Mask(word string) string
+}
+
+// MaskerFunc adapts a function to the Masker interface.
+type MaskerFunc func(word string) string
+
+func (f MaskerFunc) Mask(word string) string {
+	return f(word)
+}
+
+var (
+	// Stars replaces each character of the word with *. It is the default.
+	Stars Masker = MaskerFunc(stars)
+
+	// Grawlix replaces each character of the word with a symbol of #$@&%!,
+	// as in #$@&.
+	Grawlix Masker = MaskerFunc(grawlix)
+
+	// Remove removes the word.
+	Remove Masker = MaskerFunc(func(word string) string { return "" })
+)
+
+// RuneMasker replaces each character of the word with the rune.
+type RuneMasker rune
+
+func (r RuneMasker) Mask(word string) string {
+	return strings.Repeat(string(r), graphemes(word))
+}
+
+// KeepEndsMasker keeps the first and last character of the word and replaces
+// the others with the rune, as in f**k. Words of one or two characters are
+// masked entirely.
+type KeepEndsMasker rune
+
+func (r KeepEndsMasker) Mask(word string) string {
+	var bounds []int
+
+	graphemeClusters(word, func(start, end int) {
+		bounds = append(bounds, start)
+	})
+
+	n := len(bounds)
+
+	if n <= 2 {
+		return strings.Repeat(string(r), n)
+	}
+
+	last := bounds[n-1]
+	return word[:bounds[1]] + strings.Repeat(string(r), n-2) + word[last:]
+}
+
+// TokenMasker replaces the word with a fixed token, such as [censored].
+type TokenMasker string
+
+func (t TokenMasker) Mask(word string) string {
+	return string(t)
+}
+
+const grawlixSymbols = "#$@&%!"
+
+func grawlix(word string) string {
+	n := graphemes(word)
+	buf := make([]byte, n)
+
+	for i := range buf {
+		buf[i] = grawlixSymbols[i%len(grawlixSymbols)]
+	}
+
+	return string(buf)
+}
+
+// ParseMasker returns the masker named by s:
+//
+//	stars           f*** (default)
+//	keepends        f**k
+//	keepends:#      f##k
+//	grawlix         #$@&
+//	remove
+//	rune:#          ####
+//	token:[censored]
+func ParseMasker(s string) (Masker, error) {
+	name, arg := s, ""
+
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, arg = s[:i], s[i+1:]
+	}
+
+	switch name {
+	case "", "stars":
+		return Stars, nil
+	case "grawlix":
+		return Grawlix, nil
+	case "remove":
+		return Remove, nil
+	case "token":
+		return TokenMasker(arg), nil
+	case "rune", "keepends":
+		r := '*'
+
+		if arg != "" {
+			var size int
+			r, size = utf8.DecodeRuneInString(arg)
+
+			if size != len(arg) || r == utf8.RuneError {
+				return nil, fmt.Errorf("mask %q: expected a single character", s)
+			}
+		}
+
+		if name == "rune" {
+			return RuneMasker(r), nil
+		}
+
+		return KeepEndsMasker(r), nil
+	}
+
+	return nil, fmt.Errorf("unknown mask %q", s)
+}
+
+// maskMatches returns a copy of v where each match, ordered by Start, is
+// replaced by the mask.
+func maskMatches(v string, matches []Match, m Masker) string {
+	buf := make(appendSliceWriter, 0, len(v))
+	last := 0
+
+	for _, match := range matches {
+		buf.WriteString(v[last:match.Start])
+		buf.WriteString(m.Mask(match.Text))
+		last = match.End
+	}
+
+	buf.WriteString(v[last:])
+	return string(buf)
+}
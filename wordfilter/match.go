@@ -0,0 +1,34 @@
+package wordfilter
+
+import (
+	"unicode/utf8"
+)
+
+// Match is a blacklisted word found in a text.
+type Match struct {
+	// Byte offsets of the match in the text.
+	Start, End int
+	// Rune offsets of the match in the text.
+	RuneStart, RuneEnd int
+	// Text is the matched substring of the text.
+	Text string
+	// Entry is the blacklist entry which matched.
+	Entry string
+}
+
+// runeOffsets sets the rune offsets of matches, which are ordered by Start,
+// in s.
+func runeOffsets(s string, matches []Match) []Match {
+	var pos, n int
+
+	for i := range matches {
+		m := &matches[i]
+		n += utf8.RuneCountInString(s[pos:m.Start])
+		m.RuneStart = n
+		n += utf8.RuneCountInString(s[m.Start:m.End])
+		m.RuneEnd = n
+		pos = m.End
+	}
+
+	return matches
+}
@@ -0,0 +1,354 @@
+package wordfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A Normalizer maps text to the form in which it is matched against the
+// blacklist, for example "$h1t" to "shit".
+type Normalizer interface {
+	// Normalize returns the normalized text and the mapping of its byte
+	// offsets back to s.
+	Normalize(s string) (string, OffsetMap)
+}
+
+// OffsetMap maps byte offsets in a normalized text to the original text. For
+// each byte of the normalized text it holds the offsets of the original rune
+// it was produced from.
+type OffsetMap struct {
+	start, end []int
+}
+
+// Span returns the offsets in the original text of the normalized text
+// [start, end), which is non-empty.
+func (m OffsetMap) Span(start, end int) (int, int) {
+	return m.start[start], m.end[end-1]
+}
+
+// normalizeBuilder builds a normalized text and its OffsetMap.
+type normalizeBuilder struct {
+	buf        []byte
+	start, end []int
+}
+
+func newNormalizeBuilder(n int) *normalizeBuilder {
+	return &normalizeBuilder{
+		buf:   make([]byte, 0, n),
+		start: make([]int, 0, n),
+		end:   make([]int, 0, n),
+	}
+}
+
+// add appends s, which was produced from the original text [start, end).
+func (b *normalizeBuilder) add(s string, start, end int) {
+	b.buf = append(b.buf, s...)
+
+	for i := 0; i < len(s); i++ {
+		b.start = append(b.start, start)
+		b.end = append(b.end, end)
+	}
+}
+
+func (b *normalizeBuilder) result() (string, OffsetMap) {
+	return string(b.buf), OffsetMap{start: b.start, end: b.end}
+}
+
+// SubstitutionNormalizer replaces look-alike characters, such as the digits
+// and symbols of leetspeak, with the letters they stand for.
+type SubstitutionNormalizer struct {
+	table map[rune]string
+}
+
+// DefaultLeetTable is the substitution table used when no table is configured
+// for a language.
+var DefaultLeetTable = map[rune]string{
+	'0': "o",
+	'1': "i",
+	'3': "e",
+	'4': "a",
+	'5': "s",
+	'6': "g",
+	'7': "t",
+	'8': "b",
+	'9': "g",
+	'@': "a",
+	'$': "s",
+	'!': "i",
+	'+': "t",
+	'€': "e",
+}
+
+func NewSubstitutionNormalizer(table map[rune]string) *SubstitutionNormalizer {
+	return &SubstitutionNormalizer{table: table}
+}
+
+// Normalize substitutes every rune in the table. Sentence punctuation, such
+// as the ! in "sh!t", is only substituted inside a word, so that "fuck!" is
+// still a word followed by punctuation.
+func (n *SubstitutionNormalizer) Normalize(s string) (string, OffsetMap) {
+	b := newNormalizeBuilder(len(s))
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		sub, ok := n.table[r]
+
+		if ok && unicode.Is(unicode.Terminal_Punctuation, r) {
+			next, _ := utf8.DecodeRuneInString(s[i+size:])
+			ok = unicode.IsLetter(next) || unicode.IsNumber(next)
+		}
+
+		if !ok {
+			sub = s[i : i+size]
+		}
+
+		b.add(sub, i, i+size)
+		i += size
+	}
+
+	return b.result()
+}
+
+// ReadSubstitutions reads a substitution table with one substitution per
+// line: the character, whitespace and its replacement. Blank lines and lines
+// starting with # are skipped.
+//
+//	4 a
+//	$ s
+func ReadSubstitutions(r io.Reader) (map[rune]string, error) {
+	table := make(map[rune]string)
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 || utf8.RuneCountInString(fields[0]) != 1 {
+			return nil, fmt.Errorf("line %d: expected character and replacement, got %q", n, line)
+		}
+
+		r, _ := utf8.DecodeRuneInString(fields[0])
+		table[r] = fields[1]
+	}
+
+	return table, scanner.Err()
+}
+
+// NormalizedReplacer matches the blacklist against the normalized text and
+// masks the matching parts of the original text. The blacklist is normalized
+// the same way.
+type NormalizedReplacer struct {
+	Replacer   Replacer
+	Normalizer Normalizer
+
+	entries   map[string]string // normalized entry to blacklist entry
+	entriesMu sync.RWMutex      // entries locker
+}
+
+func NewNormalizedReplacer(replacer Replacer, normalizer Normalizer) *NormalizedReplacer {
+	return &NormalizedReplacer{
+		Replacer:   replacer,
+		Normalizer: normalizer,
+	}
+}
+
+// reload wordlist
+func (p *NormalizedReplacer) Reload(words []string) error {
+	entries := make(map[string]string, len(words))
+	normalized := make([]string, 0, len(words))
+
+	for _, w := range words {
+		n, _ := p.Normalizer.Normalize(w)
+
+		if _, ok := entries[n]; !ok {
+			entries[n] = w
+			normalized = append(normalized, n)
+		}
+	}
+
+	if err := p.Replacer.Reload(normalized); err != nil {
+		return err
+	}
+
+	p.entriesMu.Lock()
+	p.entries = entries
+	p.entriesMu.Unlock()
+	return nil
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by ****.
+func (p *NormalizedReplacer) Replace(v string) string {
+	return p.ReplaceWith(v, Stars)
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by the mask.
+func (p *NormalizedReplacer) ReplaceWith(v string, m Masker) string {
+	return maskMatches(v, p.Matches(v), m)
+}
+
+// Returns the substrings of v which match a word in the blacklist.
+func (p *NormalizedReplacer) Matches(v string) []Match {
+	n, offsets := p.Normalizer.Normalize(v)
+	p.entriesMu.RLock()
+	entries := p.entries
+	p.entriesMu.RUnlock()
+	var matches []Match
+
+	for _, m := range p.Replacer.Matches(n) {
+		start, end := offsets.Span(m.Start, m.End)
+
+		// Two matches may share an original rune which was expanded to
+		// several normalized runes.
+		if len(matches) > 0 && start < matches[len(matches)-1].End {
+			continue
+		}
+
+		entry, ok := entries[m.Entry]
+
+		if !ok {
+			entry = m.Entry
+		}
+
+		matches = append(matches, Match{Start: start, End: end, Text: v[start:end], Entry: entry})
+	}
+
+	return runeOffsets(v, matches)
+}
+
+// Reports whether the normalized v contains a word in the blacklist.
+func (p *NormalizedReplacer) Contains(v string) bool {
+	n, _ := p.Normalizer.Normalize(v)
+	return p.Replacer.Contains(n)
+}
+
+// UnicodeNormalizer folds the many ways of writing the same letter in Unicode
+// to a single form. Each option is a step of the pipeline, applied in order.
+type UnicodeNormalizer struct {
+	// RemoveInvisible removes zero-width and other invisible format
+	// characters, such as U+200B ZERO WIDTH SPACE and U+200D ZERO WIDTH
+	// JOINER.
+	RemoveInvisible bool
+	// Compatibility applies the compatibility decomposition of NFKC, which
+	// maps fullwidth letters, ligatures and letter-like symbols to plain
+	// letters.
+	Compatibility bool
+	// StripDiacritics removes combining marks, so that ù is matched as u.
+	StripDiacritics bool
+	// Confusables maps letters of other scripts which look like latin
+	// letters, such as cyrillic а, to the latin letter.
+	Confusables bool
+}
+
+// Normalize maps each rune of s independently, so the offsets of every
+// normalized rune map back to a single original rune. Runes are decomposed
+// rather than composed, which matches the same text as NFKC as long as the
+// blacklist is normalized the same way.
+func (n *UnicodeNormalizer) Normalize(s string) (string, OffsetMap) {
+	b := newNormalizeBuilder(len(s))
+	var buf []byte
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		if n.RemoveInvisible && isInvisible(r) {
+			i += size
+			continue
+		}
+
+		buf = buf[:0]
+
+		if n.Compatibility || n.StripDiacritics {
+			if n.Compatibility {
+				buf = norm.NFKD.AppendString(buf, s[i:i+size])
+			} else {
+				buf = norm.NFD.AppendString(buf, s[i:i+size])
+			}
+		} else {
+			buf = append(buf, s[i:i+size]...)
+		}
+
+		if n.StripDiacritics || n.Confusables {
+			buf = n.fold(buf)
+		}
+
+		b.add(string(buf), i, i+size)
+		i += size
+	}
+
+	return b.result()
+}
+
+// fold strips diacritics and maps confusables in the decomposed rune buf.
+func (n *UnicodeNormalizer) fold(buf []byte) []byte {
+	var tmp [utf8.UTFMax * 4]byte
+	out := tmp[:0]
+
+	for j := 0; j < len(buf); {
+		r, size := utf8.DecodeRune(buf[j:])
+		j += size
+
+		if n.StripDiacritics && unicode.Is(unicode.Mn, r) {
+			continue
+		}
+
+		if n.Confusables {
+			if c, ok := confusables[r]; ok {
+				r = c
+			}
+		}
+
+		out = utf8.AppendRune(out, r)
+	}
+
+	return append(buf[:0], out...)
+}
+
+// isInvisible reports whether r is an invisible format character or variation
+// selector.
+func isInvisible(r rune) bool {
+	return unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Variation_Selector, r) || r == '\u034f'
+}
+
+// Chain returns a Normalizer which applies each normalizer in order.
+func Chain(normalizers ...Normalizer) Normalizer {
+	return chainNormalizer(normalizers)
+}
+
+type chainNormalizer []Normalizer
+
+func (c chainNormalizer) Normalize(s string) (string, OffsetMap) {
+	var offsets OffsetMap
+
+	for i, n := range c {
+		var m OffsetMap
+		s, m = n.Normalize(s)
+
+		if i == 0 {
+			offsets = m
+			continue
+		}
+
+		// Map the offsets of this step through the previous steps.
+		for j := range m.start {
+			m.start[j], m.end[j] = offsets.Span(m.start[j], m.end[j])
+		}
+
+		offsets = m
+	}
+
+	return s, offsets
+}
@@ -0,0 +1,633 @@
+package wordfilter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A pattern is a blacklist entry which matches a family of words:
+//
+//	fuck*    words starting with fuck
+//	*hole    words ending with hole
+//	fu+ck    fuck, fuuck, fuuuck, ...
+//	[fp]uck  fuck and puck
+//	[^a-z]   any character but a to z
+//	\*       a literal *
+//
+// * matches any number of letters and digits, + repeats the preceding
+// character or class one or more times and [...] matches one of the listed
+// characters or ranges. Patterns ignore case.
+const patternMeta = `*+[\`
+
+// isPattern reports whether the entry s is a pattern.
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, patternMeta)
+}
+
+// PatternError is returned for a blacklist entry which is not a valid
+// pattern.
+type PatternError struct {
+	Pattern string
+	Err     string
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("invalid pattern %q: %s", e.Pattern, e.Err)
+}
+
+// validatePatterns returns an error for the first invalid pattern in words.
+func validatePatterns(words []string) error {
+	for _, w := range words {
+		if !isPattern(w) {
+			continue
+		}
+
+		if _, err := compilePattern(w, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// patternElem matches a single character of the text.
+type patternElem struct {
+	runes  []rune
+	ranges [][2]rune
+	negate bool
+	// any matches any character of a word.
+	any bool
+	// star matches the element zero or more times.
+	star bool
+}
+
+func (e *patternElem) match(r rune) bool {
+	if e.any {
+		return isWordRune(r) || isMidLetter(r)
+	}
+
+	r = unicode.ToLower(r)
+	in := false
+
+	for _, c := range e.runes {
+		if c == r {
+			in = true
+			break
+		}
+	}
+
+	for _, rg := range e.ranges {
+		if in {
+			break
+		}
+
+		in = rg[0] <= r && r <= rg[1]
+	}
+
+	return in != e.negate
+}
+
+// pattern is a compiled pattern. It is matched by simulating the automaton
+// of its elements, in which state i waits for element i, so matching takes
+// time linear in the length of the text times the number of elements.
+type pattern struct {
+	entry string
+	elems []patternElem
+	// literal is the longest run of single characters the pattern
+	// requires, such as "uck" of [fp]uck*, or "" if it requires none.
+	literal string
+}
+
+// compilePattern compiles the pattern s. Literal text is normalized by n, if
+// non-nil, the same way as the text it is matched against.
+func compilePattern(s string, n Normalizer) (*pattern, error) {
+	p := &pattern{entry: s}
+	fail := func(msg string) (*pattern, error) {
+		return nil, &PatternError{Pattern: s, Err: msg}
+	}
+	var literal []byte
+
+	// flush adds the pending literal text as one element per character.
+	flush := func() {
+		if len(literal) == 0 {
+			return
+		}
+
+		l := string(literal)
+		literal = literal[:0]
+
+		if n != nil {
+			l, _ = n.Normalize(l)
+		}
+
+		for _, r := range lower(l) {
+			p.elems = append(p.elems, patternElem{runes: []rune{r}})
+		}
+	}
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		switch r {
+		case '*':
+			flush()
+			p.elems = append(p.elems, patternElem{any: true, star: true})
+		case '+':
+			flush()
+
+			if len(p.elems) == 0 || p.elems[len(p.elems)-1].star {
+				return fail("+ must follow a character or class")
+			}
+
+			// x+ is matched as x followed by x*.
+			e := p.elems[len(p.elems)-1]
+			e.star = true
+			p.elems = append(p.elems, e)
+		case '[':
+			flush()
+			e, n, err := parseClass(s[i:])
+
+			if err != "" {
+				return fail(err)
+			}
+
+			p.elems = append(p.elems, e)
+			i += n
+		case '\\':
+			if i == len(s) {
+				return fail("trailing \\")
+			}
+
+			_, size = utf8.DecodeRuneInString(s[i:])
+			literal = append(literal, s[i:i+size]...)
+			i += size
+		default:
+			literal = append(literal, s[i-size:i]...)
+		}
+	}
+
+	flush()
+
+	for _, e := range p.elems {
+		if !e.star {
+			p.literal = p.requiredLiteral()
+			return p, nil
+		}
+	}
+
+	return fail("matches empty text")
+}
+
+// requiredLiteral returns the longest run of elements which each match a
+// single character exactly once. Any text the pattern matches contains the
+// run, with its characters in lower case.
+func (p *pattern) requiredLiteral() string {
+	var best, run []rune
+
+	for _, e := range p.elems {
+		if !e.star && !e.negate && len(e.runes) == 1 && len(e.ranges) == 0 {
+			run = append(run, e.runes[0])
+
+			if len(run) > len(best) {
+				best = append(best[:0], run...)
+			}
+
+			continue
+		}
+
+		run = run[:0]
+	}
+
+	return string(best)
+}
+
+// parseClass parses the character class at the start of s, which follows
+// the opening [. It returns the element and the length of the class
+// including the closing ].
+func parseClass(s string) (e patternElem, n int, err string) {
+	if strings.HasPrefix(s, "^") {
+		e.negate = true
+		n++
+	}
+
+	for {
+		if n == len(s) {
+			return e, n, "missing ]"
+		}
+
+		r, size := utf8.DecodeRuneInString(s[n:])
+		n += size
+
+		if r == ']' {
+			break
+		}
+
+		if r == '\\' {
+			if n == len(s) {
+				return e, n, "missing ]"
+			}
+
+			r, size = utf8.DecodeRuneInString(s[n:])
+			n += size
+		}
+
+		r = unicode.ToLower(r)
+
+		// A range, unless the - is the last character of the class.
+		if strings.HasPrefix(s[n:], "-") && n+1 < len(s) && s[n+1] != ']' {
+			hi, size := utf8.DecodeRuneInString(s[n+1:])
+			hi = unicode.ToLower(hi)
+
+			if hi < r {
+				return e, n, fmt.Sprintf("invalid range %c-%c", r, hi)
+			}
+
+			e.ranges = append(e.ranges, [2]rune{r, hi})
+			n += 1 + size
+			continue
+		}
+
+		e.runes = append(e.runes, r)
+	}
+
+	if len(e.runes) == 0 && len(e.ranges) == 0 {
+		return e, n, "empty class"
+	}
+
+	return e, n, ""
+}
+
+// longest returns the end of the longest match of p in s which starts at i,
+// or -1 if there is none. The states are kept in buf, which must hold twice
+// the number of elements plus two.
+func (p *pattern) longest(s string, i int, buf []bool) int {
+	n := len(p.elems) + 1
+	cur, next := buf[:n], buf[n:2*n]
+
+	for j := range cur {
+		cur[j] = false
+	}
+
+	cur[0] = true
+	p.closure(cur)
+	end := -1
+
+	for {
+		if cur[len(p.elems)] {
+			end = i
+		}
+
+		if i == len(s) {
+			return end
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		alive := false
+
+		for j := range next {
+			next[j] = false
+		}
+
+		for j := range p.elems {
+			if !cur[j] || !p.elems[j].match(r) {
+				continue
+			}
+
+			if p.elems[j].star {
+				next[j] = true
+			} else {
+				next[j+1] = true
+			}
+
+			alive = true
+		}
+
+		if !alive {
+			return end
+		}
+
+		p.closure(next)
+		cur, next = next, cur
+		i += size
+	}
+}
+
+// closure adds the states reached by skipping starred elements.
+func (p *pattern) closure(states []bool) {
+	for j, e := range p.elems {
+		if states[j] && e.star {
+			states[j+1] = true
+		}
+	}
+}
+
+// PatternReplacer adds pattern entries, such as fuck* or fu+ck, to a
+// Replacer. Plain entries are passed on to the Replacer.
+type PatternReplacer struct {
+	Replacer Replacer
+	// Normalizer, if non-nil, normalizes the text before patterns are
+	// matched, as NormalizedReplacer does for plain entries.
+	Normalizer Normalizer
+	// Word makes patterns match whole words only.
+	Word bool
+
+	patterns *patternSet
+	plain    bool         // whether Replacer holds any entries
+	mu       sync.RWMutex // patterns locker
+}
+
+// patternSet holds the compiled patterns of a PatternReplacer. The text is
+// searched once for the literals the patterns require, so that only the
+// patterns which may match are tried.
+type patternSet struct {
+	patterns []*pattern
+	literals *ahoCorasick // the distinct literals of patterns
+	literal  []int        // index in literals of each pattern, or -1
+	states   int          // size of the state buffer of longest
+}
+
+func newPatternSet(patterns []*pattern) *patternSet {
+	set := &patternSet{patterns: patterns, literal: make([]int, len(patterns))}
+	index := make(map[string]int)
+	var literals []string
+
+	for i, pat := range patterns {
+		set.literal[i] = -1
+
+		if n := 2 * (len(pat.elems) + 1); n > set.states {
+			set.states = n
+		}
+
+		if pat.literal == "" {
+			continue
+		}
+
+		j, ok := index[pat.literal]
+
+		if !ok {
+			j = len(literals)
+			index[pat.literal] = j
+			literals = append(literals, pat.literal)
+		}
+
+		set.literal[i] = j
+	}
+
+	set.literals = makeAhoCorasick(literals)
+	return set
+}
+
+// candidates returns the patterns which may match s, in their order.
+func (set *patternSet) candidates(s string) []*pattern {
+	found := make([]bool, len(set.literals.entries))
+
+	// The literals are in lower case as the elements which match them.
+	set.literals.each(strings.Map(unicode.ToLower, s), func(i int) {
+		found[i] = true
+	})
+
+	var patterns []*pattern
+
+	for i, pat := range set.patterns {
+		if j := set.literal[i]; j < 0 || found[j] {
+			patterns = append(patterns, pat)
+		}
+	}
+
+	return patterns
+}
+
+func NewPatternReplacer(replacer Replacer, normalizer Normalizer, word bool) *PatternReplacer {
+	return &PatternReplacer{
+		Replacer:   replacer,
+		Normalizer: normalizer,
+		Word:       word,
+	}
+}
+
+// compile compiles the pattern s. In Word mode a pattern which contains
+// whitespace is invalid, as it could never match a single word.
+func (p *PatternReplacer) compile(s string) (*pattern, error) {
+	if p.Word && strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+		return nil, &PatternError{Pattern: s, Err: "contains whitespace, but matches single words"}
+	}
+
+	return compilePattern(s, p.Normalizer)
+}
+
+// Validate returns an error for the first pattern in words which Reload
+// would reject.
+func (p *PatternReplacer) Validate(words []string) error {
+	for _, w := range words {
+		if !isPattern(w) {
+			continue
+		}
+
+		if _, err := p.compile(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reload wordlist. Invalid patterns are skipped and the first error is
+// returned after the valid entries are loaded.
+func (p *PatternReplacer) Reload(words []string) error {
+	var plain []string
+	var patterns []*pattern
+	var patternErr error
+
+	for _, w := range words {
+		if !isPattern(w) {
+			plain = append(plain, w)
+			continue
+		}
+
+		pat, err := p.compile(w)
+
+		if err != nil {
+			if patternErr == nil {
+				patternErr = err
+			}
+
+			continue
+		}
+
+		patterns = append(patterns, pat)
+	}
+
+	if err := p.Replacer.Reload(plain); err != nil {
+		return err
+	}
+
+	var set *patternSet
+
+	if len(patterns) > 0 {
+		set = newPatternSet(patterns)
+	}
+
+	p.mu.Lock()
+	p.patterns = set
+	p.plain = len(plain) > 0
+	p.mu.Unlock()
+	return patternErr
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by ****.
+func (p *PatternReplacer) Replace(v string) string {
+	return p.ReplaceWith(v, Stars)
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by the mask.
+func (p *PatternReplacer) ReplaceWith(v string, m Masker) string {
+	p.mu.RLock()
+	patterns := p.patterns
+	p.mu.RUnlock()
+
+	if patterns == nil {
+		return p.Replacer.ReplaceWith(v, m)
+	}
+
+	return maskMatches(v, p.Matches(v), m)
+}
+
+// Returns the substrings of v which match a word or pattern in the
+// blacklist. Of overlapping matches the leftmost, and then the longest, is
+// used.
+func (p *PatternReplacer) Matches(v string) []Match {
+	p.mu.RLock()
+	patterns, plain := p.patterns, p.plain
+	p.mu.RUnlock()
+	var matches []Match
+
+	if plain {
+		matches = p.Replacer.Matches(v)
+	}
+
+	if patterns == nil {
+		return matches
+	}
+
+	n := v
+	var offsets OffsetMap
+
+	if p.Normalizer != nil {
+		n, offsets = p.Normalizer.Normalize(v)
+	}
+
+	p.scan(n, patterns, func(start, end int, entry string) bool {
+		if p.Normalizer != nil {
+			start, end = offsets.Span(start, end)
+		}
+
+		matches = append(matches, Match{Start: start, End: end, Entry: entry})
+		return true
+	})
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+
+		return matches[i].End > matches[j].End
+	})
+
+	out := matches[:0]
+	last := 0
+
+	for _, m := range matches {
+		if m.Start < last {
+			continue
+		}
+
+		m.Text = v[m.Start:m.End]
+		out = append(out, m)
+		last = m.End
+	}
+
+	return runeOffsets(v, out)
+}
+
+// Reports whether v contains a word or pattern in the blacklist.
+func (p *PatternReplacer) Contains(v string) bool {
+	p.mu.RLock()
+	patterns, plain := p.patterns, p.plain
+	p.mu.RUnlock()
+
+	if plain && p.Replacer.Contains(v) {
+		return true
+	}
+
+	if patterns == nil {
+		return false
+	}
+
+	if p.Normalizer != nil {
+		v, _ = p.Normalizer.Normalize(v)
+	}
+
+	found := false
+
+	p.scan(v, patterns, func(start, end int, entry string) bool {
+		found = true
+		return false
+	})
+
+	return found
+}
+
+// scan calls fn with the byte offsets and entry of each match of set in s.
+// Scanning stops when fn returns false.
+func (p *PatternReplacer) scan(s string, set *patternSet, fn func(start, end int, entry string) bool) {
+	patterns := set.candidates(s)
+
+	if len(patterns) == 0 {
+		return
+	}
+
+	buf := make([]bool, set.states)
+
+	if p.Word {
+		words(s, func(start, end int) bool {
+			for _, pat := range patterns {
+				if pat.longest(s[:end], start, buf) == end {
+					return fn(start, end, pat.entry)
+				}
+			}
+
+			return true
+		})
+
+		return
+	}
+
+	for i := 0; i < len(s); {
+		best, entry := -1, ""
+
+		for _, pat := range patterns {
+			if end := pat.longest(s, i, buf); end > best {
+				best, entry = end, pat.entry
+			}
+		}
+
+		if best > i {
+			if !fn(i, best, entry) {
+				return
+			}
+
+			i = best
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+}
@@ -3,6 +3,9 @@ package wordfilter
 import (
 	"fmt"
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // starmap used to draw N stars in place of a blacklisted word.
@@ -14,8 +17,23 @@ func init() {
 	}
 }
 
+// stars returns the stars drawn in place of the blacklisted word w, one for
+// each user-perceived character.
+func stars(w string) string {
+	n := graphemes(w)
+
+	if n < len(starmap) {
+		return starmap[n]
+	}
+
+	return strings.Repeat("*", n)
+}
+
 type Replacer interface {
 	Replace(v string) string
+	ReplaceWith(v string, m Masker) string
+	Matches(v string) []Match
+	Contains(v string) bool
 	Reload(words []string) error
 }
 
@@ -52,3 +70,57 @@ func getStringWriter(w io.Writer) stringWriterIface {
 	}
 	return sw
 }
+
+// lower returns s with all runes mapped to lower case, except runes whose
+// lower case has a different encoded length. Byte offsets in s and lower(s)
+// are therefore the same.
+func lower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= utf8.RuneSelf || 'A' <= c && c <= 'Z' {
+			return string(appendLower(make([]byte, 0, len(s)), s))
+		}
+	}
+
+	return s
+}
+
+// appendLower appends lower(s) to b.
+func appendLower(b []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+
+			b = append(b, c)
+			i++
+			continue
+		}
+
+		r, n := utf8.DecodeRuneInString(s[i:])
+		l := unicode.ToLower(r)
+
+		if l != r && utf8.RuneLen(l) == n {
+			b = utf8.AppendRune(b, l)
+		} else {
+			b = append(b, s[i:i+n]...)
+		}
+
+		i += n
+	}
+
+	return b
+}
+
+// isASCII reports whether s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
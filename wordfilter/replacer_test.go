@@ -1,7 +1,17 @@
 package wordfilter
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/simonz05/profanity/wordlist"
 )
 
 var smallList = []string{"fuck", "duck", "puck", "suck", "eff"}
@@ -40,9 +50,147 @@ func TestSetReplacer(t *testing.T) {
 		{"foo ffuck", "foo ffuck"},
 		{"eff", "***"},
 		{"eff\n", "***\n"},
+		{"eff\r\n", "***\r\n"},
+		{"fuck, duck!", "****, ****!"},
+		{"(fuck)", "(****)"},
+		{"fuck\tsuck\u00a0puck", "****\t****\u00a0****"},
+		{"a fuck.", "a ****."},
+		{"fuck's", "fuck's"},
+		{"«Fuck»", "«****»"},
+		{"fuck_duck", "fuck_duck"},
+	}
+
+	repl := NewSetReplacer()
+	repl.Reload(smallList)
+
+	for i, x := range tests {
+		if out := repl.Replace(x.in); out != x.out {
+			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
+		}
+	}
+}
+
+func TestSetReplacerPhrases(t *testing.T) {
+	tests := []*ProfanityTest{
+		{"2 girls 1 cup", "*************"},
+		{"2  Girls\t1\n cup!", "***************!"},
+		{"2 girls 1 cups", "2 girls 1 cups"},
+		{"2 girls, 1 cup", "2 girls, 1 cup"},
+		{"ball", "****"},
+		{"ball gag", "********"},
+		{"ball  gaga", "****  gaga"},
+		{"ball ball gag", "**** ********"},
+		{"f-word f - word", "****** f - word"},
+		{"f word", "f word"},
 	}
 
 	repl := NewSetReplacer()
+	repl.Reload([]string{"2 girls 1 cup", "ball", "ball gag", "f-word", " eff "})
+
+	for i, x := range tests {
+		if out := repl.Replace(x.in); out != x.out {
+			t.Fatalf("#%d: expected %q, got %q", i, x.out, out)
+		}
+	}
+
+	matches := repl.Matches("an eff ball  gag")
+	expected := []Match{{3, 6, 3, 6, "eff", " eff "}, {7, 16, 7, 16, "ball  gag", "ball gag"}}
+
+	if !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("expected %v, got %v", expected, matches)
+	}
+}
+
+func TestPatternReplacer(t *testing.T) {
+	list := []string{"fuck*", "*hole", "fu+ck", "[dp]uck", "sh[^a-z]t", "a\\*b", "eff", "[qz][qz]+"}
+	tests := []struct {
+		repl Replacer
+		in   string
+		out  string
+	}{
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "fucking fuck", "******* ****"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "ffucking", "ffucking"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "asshole hole holes", "******* **** holes"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "fuuuuck fck", "******* fck"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "Duck puck suck", "**** **** suck"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "sh1t shit", "**** shit"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "ab eff", "ab ***"},
+		{NewPatternReplacer(NewAhoCorasickReplacer(), nil, false), "motherfuckers assholes", "mother******* *******s"},
+		{NewPatternReplacer(NewAhoCorasickReplacer(), nil, false), "xfuuuckx effort", "x******x ***ort"},
+		{NewPatternReplacer(NewAhoCorasickReplacer(), nil, false), "a*b ab", "*** ab"},
+		{NewPatternReplacer(NewSetReplacer(), NewSubstitutionNormalizer(DefaultLeetTable), true), "fuck3d $h1t", "****** $h1t"},
+		{NewPatternReplacer(NewSetReplacer(), nil, true), "zqz q Qz", "*** q **"},
+		{NewPatternReplacer(NewAhoCorasickReplacer(), nil, false), "aqqa", "a**a"},
+	}
+
+	for i, x := range tests {
+		if err := x.repl.Reload(list); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+
+		if out := x.repl.Replace(x.in); out != x.out {
+			t.Fatalf("#%d: expected %q, got %q", i, x.out, out)
+		}
+
+		if profane := x.repl.Contains(x.in); profane != (x.in != x.out) {
+			t.Fatalf("#%d: expected contains %v, got %v", i, x.in != x.out, profane)
+		}
+	}
+
+	// an empty blacklist clears the replacer
+	for i, x := range tests {
+		if err := x.repl.Reload(nil); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+
+		if out := x.repl.Replace(x.in); out != x.in {
+			t.Fatalf("#%d: expected %q, got %q", i, x.in, out)
+		}
+	}
+
+	invalid := []string{"fu[ck", "+uck", "fu*+ck", "fu++ck", "*", "f[]ck", "f[z-a]ck", "fuck\\"}
+
+	for i, x := range invalid {
+		err := validatePatterns([]string{"ok", x})
+
+		if _, ok := err.(*PatternError); !ok {
+			t.Fatalf("#%d: expected pattern error for %q, got %v", i, x, err)
+		}
+	}
+
+	w := NewWordfilter(wordlist.NewMemoryWordlist())
+
+	if err := w.Set([]string{"fu[ck"}); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+
+	// a word pattern cannot match across words
+	if _, ok := w.Set([]string{"fuck* you"}).(*PatternError); !ok {
+		t.Fatal("expected pattern with whitespace to be rejected")
+	}
+
+	if err := NewPatternReplacer(NewAhoCorasickReplacer(), nil, false).Validate([]string{"fuck* you"}); err != nil {
+		t.Fatalf("expected pattern with whitespace to be valid for substrings, got %v", err)
+	}
+
+	if n, _ := w.Count(); n != 0 {
+		t.Fatalf("expected empty wordlist, got %d entries", n)
+	}
+}
+
+func TestAhoCorasickReplacer(t *testing.T) {
+	tests := []*ProfanityTest{
+		{"foo", "foo"},
+		{"foo fuck", "foo ****"},
+		{"foo fUCK", "foo ****"},
+		{"foo uck", "foo uck"},
+		{"foo ffuck", "foo f****"},
+		{"eff", "***"},
+		{"effuck", "***uck"},
+		{"ÅÅ DUCK", "ÅÅ ****"},
+	}
+
+	repl := NewAhoCorasickReplacer()
 	repl.Reload(smallList)
 
 	for i, x := range tests {
@@ -50,6 +198,301 @@ func TestSetReplacer(t *testing.T) {
 			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
 		}
 	}
+
+	// leftmost, then longest
+	repl.Reload([]string{"bc", "abcd", "abc", "cdef", "x", "xyz"})
+	tests = []*ProfanityTest{
+		{"abcd", "****"},
+		{"abce", "***e"},
+		{"zbcdef", "z**def"},
+		{"abcdef", "****ef"},
+		{"xxy xyzz", "**y ***z"},
+	}
+
+	for i, x := range tests {
+		if out := repl.Replace(x.in); out != x.out {
+			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
+		}
+	}
+
+	// StringReplacer uses the first listed entry instead of the longest
+	list := []string{"he", "hers", "his"}
+	repl.Reload(list)
+	str := NewStringReplacer()
+	str.Reload(list)
+
+	if out := repl.Replace("ahishers"); out != "a*******" {
+		t.Fatalf("expected a*******, got %s", out)
+	}
+
+	if out := str.Replace("ahishers"); out != "a*****rs" {
+		t.Fatalf("expected a*****rs, got %s", out)
+	}
+}
+
+func TestNormalizedReplacer(t *testing.T) {
+	tests := []struct {
+		repl Replacer
+		in   string
+		out  string
+	}{
+		{NewSetReplacer(), "foo", "foo"},
+		{NewSetReplacer(), "foo fuck", "foo ****"},
+		{NewSetReplacer(), "$uck d(_)ck 3ff!", "**** d(_)ck ***!"},
+		{NewSetReplacer(), "5uck! 3ff.", "****! ***."},
+		{NewSetReplacer(), "wh@t 2000", "wh@t 2000"},
+		{NewStringReplacer(), "ff$uck f3ff", "ff**** f***"},
+		{NewStringReplacer(), "SU(K $UCK", "SU(K ****"},
+	}
+
+	for i, x := range tests {
+		repl := NewNormalizedReplacer(x.repl, NewSubstitutionNormalizer(DefaultLeetTable))
+		repl.Reload(smallList)
+
+		if out := repl.Replace(x.in); out != x.out {
+			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
+		}
+
+		if repl.Contains(x.in) != (x.in != x.out) {
+			t.Fatalf("#%d: expected contains %v", i, x.in != x.out)
+		}
+	}
+
+	table, err := ReadSubstitutions(strings.NewReader("# comment\n\n( c\n\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repl := NewNormalizedReplacer(NewSetReplacer(), NewSubstitutionNormalizer(table))
+	repl.Reload([]string{"fuck", "Phuck"})
+	exp := []Match{{0, 4, 0, 4, "fu(k", "fuck"}, {5, 10, 5, 10, "PHU(K", "Phuck"}}
+
+	if out := repl.Matches("fu(k PHU(K"); !reflect.DeepEqual(out, exp) {
+		t.Fatalf("expected %v, got %v", exp, out)
+	}
+
+	if _, err := ReadSubstitutions(strings.NewReader("abc")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestUnicodeNormalizer(t *testing.T) {
+	unicodeNormalizer := &UnicodeNormalizer{
+		RemoveInvisible: true,
+		Compatibility:   true,
+		StripDiacritics: true,
+		Confusables:     true,
+	}
+
+	tests := []struct {
+		repl Replacer
+		in   string
+		out  []Match
+	}{
+		{NewSetReplacer(), "foo", nil},
+		{NewSetReplacer(), "a fùck", []Match{{2, 7, 2, 6, "fùck", "fuck"}}},
+		{NewSetReplacer(), "a fu\u0300ck", []Match{{2, 8, 2, 7, "fu\u0300ck", "fuck"}}},
+		{NewSetReplacer(), "a ｆｕｃｋ", []Match{{2, 14, 2, 6, "ｆｕｃｋ", "fuck"}}},
+		{NewSetReplacer(), "a f\u200duck", []Match{{2, 9, 2, 7, "f\u200duck", "fuck"}}},
+		{NewSetReplacer(), "a \u200bfuck\u200b", []Match{{5, 9, 3, 7, "fuck", "fuck"}}},
+		{NewSetReplacer(), "a sиck", nil},
+		{NewStringReplacer(), "a dυсk", []Match{{2, 8, 2, 6, "dυсk", "duck"}}},
+		{NewStringReplacer(), "a ＳＵＣＫ", []Match{{2, 14, 2, 6, "ＳＵＣＫ", "suck"}}},
+		{NewStringReplacer(), "ﬀeﬀ", []Match{{3, 7, 1, 3, "eﬀ", "eff"}}},
+	}
+
+	for i, x := range tests {
+		repl := NewNormalizedReplacer(x.repl, unicodeNormalizer)
+		repl.Reload(smallList)
+
+		if out := repl.Matches(x.in); !reflect.DeepEqual(out, x.out) {
+			t.Fatalf("#%d: expected %v, got %v", i, x.out, out)
+		}
+	}
+
+	// unicode folding followed by leetspeak substitution
+	repl := NewNormalizedReplacer(NewSetReplacer(), Chain(unicodeNormalizer, NewSubstitutionNormalizer(DefaultLeetTable)))
+	repl.Reload([]string{"Fück"})
+	exp := []Match{{2, 17, 2, 8, "ｆ\u200bu\u0308ç\U0001d48c", "Fück"}}
+
+	if out := repl.Matches("a ｆ\u200bu\u0308ç\U0001d48c ３ff"); !reflect.DeepEqual(out, exp) {
+		t.Fatalf("expected %v, got %v", exp, out)
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{"", nil},
+		{" \t\n", nil},
+		{"foo", []string{"foo"}},
+		{"foo bar\r\nbaz ", []string{"foo", "bar", "baz"}},
+		{"(foo), bar! baz?", []string{"foo", "bar", "baz"}},
+		{"don't 3.14 1,000 end.", []string{"don't", "3.14", "1,000", "end"}},
+		{"'quoted' a-b", []string{"quoted", "a", "b"}},
+		{"ünïcödé\u00a0слово 単語", []string{"ünïcödé", "слово", "単語"}},
+	}
+
+	for i, x := range tests {
+		var out []string
+
+		words(x.in, func(start, end int) bool {
+			out = append(out, x.in[start:end])
+			return true
+		})
+
+		if !reflect.DeepEqual(out, x.out) {
+			t.Fatalf("#%d: expected %q, got %q", i, x.out, out)
+		}
+	}
+}
+
+func TestMasking(t *testing.T) {
+	tests := []struct {
+		repl Replacer
+		in   string
+		out  string
+	}{
+		{NewSetReplacer(), "вот блять", "вот *****"},
+		{NewStringReplacer(), "вот блять", "вот *****"},
+		{NewAhoCorasickReplacer(), "вот блять", "вот *****"},
+		{NewSetReplacer(), "これは 馬鹿", "これは **"},
+		{NewStringReplacer(), "これは馬鹿だ", "これは**だ"},
+		{NewAhoCorasickReplacer(), "これは馬鹿だ", "これは**だ"},
+		{NewSetReplacer(), "a supercalifragilisticexpialidocious", "a **********************************"},
+		{NewStringReplacer(), "a supercalifragilisticexpialidocious", "a **********************************"},
+		{NewSetReplacer(), "a fu\u0308ck", "a ****"},
+		{NewStringReplacer(), "a 🤬🏿‍🔥 🇸🇪🇸🇪", "a * **"},
+		{NewAhoCorasickReplacer(), "a 🤬🏿‍🔥 🇸🇪🇸🇪", "a * **"},
+	}
+
+	for i, x := range tests {
+		x.repl.Reload([]string{"блять", "馬鹿", "supercalifragilisticexpialidocious", "fu\u0308ck", "🤬🏿‍🔥", "🇸🇪"})
+
+		if out := x.repl.Replace(x.in); out != x.out {
+			t.Fatalf("#%d: expected %s, got %s", i, x.out, out)
+		}
+	}
+
+	tests2 := []struct {
+		in  string
+		out int
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"\r\n", 1},
+		{"e\u0301", 1},
+		{"👩‍👩‍👧", 1},
+		{"👍🏽👍", 2},
+		{"🇸🇪🇳🇴🇩", 3},
+		{"각", 1},
+		{"\u1100\u1161\u11a8", 1},
+	}
+
+	for i, x := range tests2 {
+		if out := graphemes(x.in); out != x.out {
+			t.Fatalf("#%d: expected %d, got %d", i, x.out, out)
+		}
+	}
+}
+
+func TestMaskers(t *testing.T) {
+	tests := []struct {
+		mask string
+		in   string
+		out  string
+	}{
+		{"", "foo fuck", "foo ****"},
+		{"stars", "foo fuck", "foo ****"},
+		{"keepends", "foo fuck eff", "foo f**k e*f"},
+		{"keepends:#", "foo fùck", "foo f##k"},
+		{"grawlix", "foo fuck eff", "foo #$@& #$@"},
+		{"remove", "foo fuck, duck", "foo , "},
+		{"rune:█", "foo fuck", "foo ████"},
+		{"token:[censored]", "foo fuck", "foo [censored]"},
+	}
+
+	repls := []Replacer{NewSetReplacer(), NewStringReplacer(), NewAhoCorasickReplacer()}
+
+	for i, x := range tests {
+		m, err := ParseMasker(x.mask)
+
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+
+		for _, repl := range repls {
+			repl.Reload(append(smallList, "fùck"))
+
+			if out := repl.ReplaceWith(x.in, m); out != x.out {
+				t.Fatalf("#%d: %T expected %s, got %s", i, repl, x.out, out)
+			}
+		}
+	}
+
+	for _, mask := range []string{"foo", "rune:ab", "keepends:xy"} {
+		if _, err := ParseMasker(mask); err == nil {
+			t.Fatalf("%s: expected error", mask)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		repl Replacer
+		in   string
+		out  []Match
+	}{
+		{NewSetReplacer(), "foo", nil},
+		{NewSetReplacer(), "foo fUCK", []Match{{4, 8, 4, 8, "fUCK", "fuck"}}},
+		{NewSetReplacer(), "åå eff ffuck", []Match{{5, 8, 3, 6, "eff", "eff"}}},
+		{NewStringReplacer(), "foo", nil},
+		{NewStringReplacer(), "åå ffuck", []Match{{6, 10, 4, 8, "fuck", "fuck"}}},
+		{NewStringReplacer(), "åå ffUck duck", []Match{{6, 10, 4, 8, "fUck", "fuck"}, {11, 15, 9, 13, "duck", "duck"}}},
+		{NewAhoCorasickReplacer(), "foo", nil},
+		{NewAhoCorasickReplacer(), "åå ffUck duck", []Match{{6, 10, 4, 8, "fUck", "fuck"}, {11, 15, 9, 13, "duck", "duck"}}},
+	}
+
+	for i, x := range tests {
+		x.repl.Reload(smallList)
+		out := x.repl.Matches(x.in)
+
+		if !reflect.DeepEqual(out, x.out) {
+			t.Fatalf("#%d: expected %v, got %v", i, x.out, out)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		repl Replacer
+		in   string
+		out  bool
+	}{
+		{NewSetReplacer(), "foo", false},
+		{NewSetReplacer(), "foo fUCK", true},
+		{NewSetReplacer(), "foo ffuck", false},
+		{NewStringReplacer(), "foo", false},
+		{NewStringReplacer(), "foo ffUCK", true},
+		{NewStringReplacer(), "ÅÅ ffUCK", true},
+		{NewAhoCorasickReplacer(), "foo", false},
+		{NewAhoCorasickReplacer(), "ÅÅ ffUCK", true},
+	}
+
+	for i, x := range tests {
+		x.repl.Reload(smallList)
+
+		if out := x.repl.Contains(x.in); out != x.out {
+			t.Fatalf("#%d: expected %v, got %v", i, x.out, out)
+		}
+
+		if allocs := testing.AllocsPerRun(10, func() { x.repl.Contains("Foo Bar baz") }); allocs != 0 {
+			t.Fatalf("#%d: expected no allocations, got %v", i, allocs)
+		}
+	}
 }
 
 func BenchmarkBoyer(b *testing.B) {
@@ -72,6 +515,26 @@ func BenchmarkSmallBoyerList(b *testing.B) {
 	}
 }
 
+func BenchmarkAhoCorasick(b *testing.B) {
+	repl := NewAhoCorasickReplacer()
+	repl.Reload(largeList)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Replace("EFG")
+	}
+}
+
+func BenchmarkSmallAhoCorasickList(b *testing.B) {
+	repl := NewAhoCorasickReplacer()
+	repl.Reload(smallList)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Replace("foo fuck")
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	repl := NewSetReplacer()
 	repl.Reload(largeList)
@@ -102,6 +565,26 @@ func BenchmarkLargeInputString(b *testing.B) {
 	}
 }
 
+func BenchmarkLargeInputPattern(b *testing.B) {
+	repl := NewPatternReplacer(NewStringReplacer(), nil, false)
+	repl.Reload(smallList)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Replace("foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck")
+	}
+}
+
+func BenchmarkLargeInputAhoCorasick(b *testing.B) {
+	repl := NewAhoCorasickReplacer()
+	repl.Reload(smallList)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Replace("foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck")
+	}
+}
+
 func BenchmarkLargeInputSet(b *testing.B) {
 	repl := NewSetReplacer()
 	repl.Reload(smallList)
@@ -111,3 +594,261 @@ func BenchmarkLargeInputSet(b *testing.B) {
 		repl.Replace("foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck foo fuck")
 	}
 }
+
+// generatedList returns n distinct words of 4 to 9 letters.
+func generatedList(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	seen := make(map[string]bool, n)
+	words := make([]string, 0, n)
+
+	for len(words) < n {
+		w := make([]byte, 4+r.Intn(6))
+
+		for i := range w {
+			w[i] = byte('a' + r.Intn(26))
+		}
+
+		if !seen[string(w)] {
+			seen[string(w)] = true
+			words = append(words, string(w))
+		}
+	}
+
+	return words
+}
+
+func benchmarkGeneratedList(b *testing.B, repl Replacer) {
+	list := generatedList(10000)
+	repl.Reload(list)
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog "+list[42]+" ", 100)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Replace(text)
+	}
+}
+
+func BenchmarkGeneratedListString(b *testing.B) {
+	benchmarkGeneratedList(b, NewStringReplacer())
+}
+
+func BenchmarkGeneratedListAhoCorasick(b *testing.B) {
+	benchmarkGeneratedList(b, NewAhoCorasickReplacer())
+}
+
+func BenchmarkGeneratedListSet(b *testing.B) {
+	benchmarkGeneratedList(b, NewSetReplacer())
+}
+
+func BenchmarkLargeInputContains(b *testing.B) {
+	repl := NewStringReplacer()
+	repl.Reload(smallList)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Contains("foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar foo bar")
+	}
+}
+
+func BenchmarkPatterns(b *testing.B) {
+	patterns := make([]string, 500)
+
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("w%03d[aeiou]+x*", i)
+	}
+
+	text := strings.Repeat("the quick brown fox jumps over the lazy w042aax dog ", 180)
+	repl := NewPatternReplacer(NewSetReplacer(), nil, true)
+	repl.Reload(patterns)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repl.Replace(text)
+	}
+}
+
+func TestEmptyKeepsBase(t *testing.T) {
+	base := wordlist.NewMemoryWordlist()
+	base.Set([]string{"xxxx"})
+	w := NewWordfilter(wordlist.NewMemoryWordlist())
+	w.Base = base
+	w.Set([]string{"yyyy"})
+
+	if err := w.Empty(); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := w.Sanitize("xxxx yyyy"); out != "**** yyyy" {
+		t.Fatalf("expected the base list to be kept, got %q", out)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	w := NewWordfilter(wordlist.NewMemoryWordlist())
+	w.Replacer = NewAhoCorasickReplacer()
+	w.Set([]string{"cunt", "ass"})
+
+	if out := w.Sanitize("Scunthorpe ass classic"); out != "S****horpe *** cl***ic" {
+		t.Fatalf("expected masked text, got %q", out)
+	}
+
+	w.Allowlist().Set([]string{"scunthorpe", "classic"})
+
+	tests := []ProfanityTest{
+		{"Scunthorpe ass classic", "Scunthorpe *** classic"},
+		{"SCUNTHORPE cunt", "SCUNTHORPE ****"},
+		{"scunthorp", "s****horp"},
+		{"classicass", "classic***"},
+	}
+
+	for i, x := range tests {
+		if out := w.Sanitize(x.in); out != x.out {
+			t.Fatalf("#%d: expected %q, got %q", i, x.out, out)
+		}
+
+		if profane := w.Contains(x.in); !profane {
+			t.Fatalf("#%d: expected profane", i)
+		}
+	}
+
+	if w.Contains("Scunthorpe classic") {
+		t.Fatal("expected allowlisted words to be clean")
+	}
+
+	w.Allowlist().Delete([]string{"scunthorpe"})
+
+	if out := w.Sanitize("Scunthorpe classic"); out != "S****horpe classic" {
+		t.Fatalf("expected masked text, got %q", out)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	w := NewWordfilter(wordlist.NewMemoryWordlist())
+	w.SetEntries([]wordlist.Entry{
+		{Word: "darn", Severity: wordlist.Mild},
+		{Word: "fuck", Severity: wordlist.Strong, Categories: []string{"sexual"}},
+		{Word: "slur", Severity: wordlist.Severe, Categories: []string{"slur"}},
+		{Word: "eff"},
+	})
+
+	in := "darn fuck slur eff"
+	tests := []struct {
+		opts *Options
+		out  string
+	}{
+		{nil, "**** **** **** ***"},
+		{&Options{MinSeverity: wordlist.Strong}, "darn **** **** ***"},
+		{&Options{MinSeverity: wordlist.Severe}, "darn fuck **** ***"},
+		{&Options{Categories: []string{"slur"}}, "darn fuck **** eff"},
+		{&Options{Categories: []string{"sexual", "slur"}, MinSeverity: wordlist.Severe}, "darn fuck **** eff"},
+		{&Options{Categories: []string{"drugs"}}, in},
+	}
+
+	for i, x := range tests {
+		if out := w.SanitizeWith(in, x.opts); out != x.out {
+			t.Fatalf("#%d: expected %q, got %q", i, x.out, out)
+		}
+
+		if profane := w.ContainsWith(in, x.opts); profane != (x.out != in) {
+			t.Fatalf("#%d: expected contains %v, got %v", i, x.out != in, profane)
+		}
+	}
+
+	if e := w.Entry("fuck"); e.Severity != wordlist.Strong || !reflect.DeepEqual(e.Categories, []string{"sexual"}) {
+		t.Fatalf("unexpected entry %v", e)
+	}
+}
+
+func TestMaskStream(t *testing.T) {
+	repl := NewPatternReplacer(NewSetReplacer(), nil, true)
+	repl.Reload([]string{"ball", "ball gag", "2 girls 1 cup", "fuck*", "eff"})
+
+	var text []string
+
+	for i := 0; i < 200; i++ {
+		text = append(text, "ball gag", "ball", "x", "2 girls\n1 cup", "fuckfuckfuck", "effort", "eff")
+	}
+
+	in := strings.Join(text, " ")
+	expected := repl.Replace(in)
+
+	for _, window := range []int{16, 23, 64, 1024} {
+		var out strings.Builder
+
+		if err := maskStream(&out, iotest.HalfReader(strings.NewReader(in)), repl.Matches, Stars, window); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != expected {
+			t.Fatalf("window %d: expected %q, got %q", window, expected, out.String())
+		}
+	}
+
+	// a match longer than the window is written whole
+	var out strings.Builder
+	in = "a " + strings.Repeat("fuck", 20) + " b"
+
+	if err := maskStream(&out, iotest.OneByteReader(strings.NewReader(in)), repl.Matches, Stars, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "a "+strings.Repeat("*", 80)+" b" {
+		t.Fatalf("expected a long match, got %q", out.String())
+	}
+}
+
+func TestSanitizeStream(t *testing.T) {
+	w := NewWordfilter(wordlist.NewMemoryWordlist())
+	w.SetEntries([]wordlist.Entry{{Word: "xxx", Severity: wordlist.Severe}, {Word: "yyy", Severity: wordlist.Mild}})
+	w.Allowlist().Set([]string{"xxxyyy"})
+	in := strings.Repeat("xxx yyy xxxyyy ", 10000)
+	opts := &Options{Mask: TokenMasker("#"), MinSeverity: wordlist.Strong}
+	var out strings.Builder
+
+	if err := w.SanitizeStream(&out, strings.NewReader(in), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := strings.Repeat("# yyy xxxyyy ", 10000); out.String() != expected {
+		t.Fatal("expected the stream to be sanitized as a whole")
+	}
+
+	if err := w.SanitizeStream(&out, iotest.ErrReader(io.ErrUnexpectedEOF), nil); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected read error, got %v", err)
+	}
+}
+
+type testObserver struct {
+	matched, reloads, entries int
+}
+
+func (o *testObserver) Matched(n int) {
+	o.matched += n
+}
+
+func (o *testObserver) Reloaded(entries int, d time.Duration) {
+	o.reloads++
+	o.entries = entries
+}
+
+func TestObserver(t *testing.T) {
+	o := new(testObserver)
+	w := NewWordfilter(wordlist.NewMemoryWordlist())
+	w.Observer = o
+	w.Set([]string{"xxx", "yyy"})
+
+	if o.reloads != 1 || o.entries != 2 {
+		t.Fatalf("expected a reload of 2 entries, got %d %d", o.reloads, o.entries)
+	}
+
+	w.Sanitize("xxx yyy zzz")
+	w.Matches("xxx")
+	w.Contains("xxx")
+	w.SanitizeStream(ioutil.Discard, strings.NewReader("yyy yyy"), nil)
+
+	if o.matched != 5 {
+		t.Fatalf("expected 5 matches, got %d", o.matched)
+	}
+}
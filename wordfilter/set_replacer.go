@@ -1,112 +1,232 @@
 package wordfilter
 
 import (
-	"errors"
 	"strings"
 	"sync"
-
-	"github.com/simonz05/util/math"
 )
 
-// A thread-safe word filter
+// A thread-safe word filter which matches whole words. Entries of several
+// words, such as "ball gag", match the same words separated by any amount of
+// whitespace.
 type SetReplacer struct {
-	repl   map[string]string
+	repl   map[string]*setNode
 	replMu sync.RWMutex // repl locker
 }
 
+// setNode is a word of a blacklist entry, or the separator following it.
+// Longer entries continue in next, which alternates between separators and
+// words.
+type setNode struct {
+	// entry is the blacklist entry ending at this word, or "".
+	entry string
+	next  map[string]*setNode
+}
+
+func (n *setNode) add(key string) *setNode {
+	if n.next == nil {
+		n.next = make(map[string]*setNode)
+	}
+
+	c, ok := n.next[key]
+
+	if !ok {
+		c = new(setNode)
+		n.next[key] = c
+	}
+
+	return c
+}
+
 // Returns a new word filter. The word filter is empty by default.
 func NewSetReplacer() *SetReplacer {
 	return &SetReplacer{
-		repl: make(map[string]string),
+		repl: make(map[string]*setNode),
 	}
 }
 
 // reload wordlist
 func (p *SetReplacer) Reload(words []string) error {
-	repl, err := p.buildReplacer(words)
-
-	if err != nil {
-		return err
-	}
-
+	repl := p.buildReplacer(words)
 	p.replMu.Lock()
 	p.repl = repl
 	p.replMu.Unlock()
 	return nil
 }
 
-// Build string replacer from blacklist
-func (p *SetReplacer) buildReplacer(words []string) (map[string]string, error) {
-	var starindex int
-	n := len(words)
+// Build lookup table from blacklist, mapping each lowercase word to the
+// blacklist entry. Entries of several words are added word by word.
+func (p *SetReplacer) buildReplacer(words []string) map[string]*setNode {
+	root := &setNode{next: make(map[string]*setNode, len(words))}
 
-	if n == 0 {
-		return nil, errors.New("Got empty blacklist")
-	}
+	for _, w := range words {
+		key := strings.TrimSpace(lower(w))
+
+		if key == "" {
+			continue
+		}
 
-	repl := make(map[string]string, n)
+		node := root
+		last := 0
 
-	for _, w := range words {
-		starindex = math.IntMin(len(w), len(starmap)-1)
-		repl[strings.ToLower(w)] = starmap[starindex]
+		start, end := nextWord(key, 0)
+
+		// An entry which does not start and end with a word can not match
+		// and is kept as is.
+		if start != 0 || !endsWithWord(key) {
+			node = node.add(key)
+		} else {
+			for start >= 0 {
+				if start > 0 {
+					node = node.add(separator(key[last:start]))
+				}
+
+				node = node.add(key[start:end])
+				last = end
+				start, end = nextWord(key, end)
+			}
+		}
+
+		if node.entry == "" {
+			node.entry = w
+		}
 	}
 
-	return repl, nil
+	return root.next
+}
+
+// endsWithWord reports whether the last word of s ends at the end of s.
+func endsWithWord(s string) bool {
+	end := -1
+
+	words(s, func(_, e int) bool {
+		end = e
+		return true
+	})
+
+	return end == len(s)
 }
 
 // Returns a copy of string v where each word in the text that matches a word
 // in the blacklist is replaced by ****.
 func (p *SetReplacer) Replace(v string) string {
+	return p.ReplaceWith(v, Stars)
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by the mask.
+func (p *SetReplacer) ReplaceWith(v string, m Masker) string {
 	p.replMu.RLock()
 	defer p.replMu.RUnlock()
 	buf := make(appendSliceWriter, 0, len(v))
-	p.WriteString(&buf, v)
+	p.WriteString(&buf, v, m)
 	return string(buf)
 }
 
-func (p *SetReplacer) WriteString(buf *appendSliceWriter, s string) {
-	sepCr := "\r\n"
-	sepNl := "\n"
-	sepSpace := " "
-
-	start := 0
-	var sep string
-
-	for i := 0; i+2 < len(s); i++ {
-		if s[i] == sepNl[0] {
-			sep = "\n"
-		} else if s[i] == sepSpace[0] {
-			sep = " "
-		} else if s[i:i+2] == sepCr {
-			sep = sepCr
-		} else {
+func (p *SetReplacer) WriteString(buf *appendSliceWriter, s string, m Masker) {
+	last := 0
+
+	p.scan(s, func(start, end int, entry string) bool {
+		buf.WriteString(s[last:start])
+		buf.WriteString(m.Mask(s[start:end]))
+		last = end
+		return true
+	})
+
+	buf.WriteString(s[last:])
+}
+
+// Returns the words in v which match a word in the blacklist.
+func (p *SetReplacer) Matches(v string) []Match {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	var matches []Match
+
+	p.scan(v, func(start, end int, entry string) bool {
+		matches = append(matches, Match{Start: start, End: end, Text: v[start:end], Entry: entry})
+		return true
+	})
+
+	return runeOffsets(v, matches)
+}
+
+// Reports whether any word in v is in the blacklist. It returns at the first
+// match.
+func (p *SetReplacer) Contains(v string) bool {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	found := false
+
+	p.scan(v, func(start, end int, entry string) bool {
+		found = true
+		return false
+	})
+
+	return found
+}
+
+// scan calls fn with the byte offsets and blacklist entry of each word or
+// phrase in s which is in the blacklist. Of entries starting at the same word
+// the longest is used. Scanning stops when fn returns false.
+func (p *SetReplacer) scan(s string, fn func(start, end int, entry string) bool) {
+	for i := 0; ; {
+		start, end := nextWord(s, i)
+
+		if start < 0 {
+			return
+		}
+
+		i = end
+		node := lookup(p.repl, s[start:end])
+
+		if node == nil {
 			continue
 		}
 
-		buf.WriteString(p.replace(s[start:i]))
-		buf.WriteString(sep)
-		start = i + len(sep)
-		i += len(sep) - 1
-	}
+		entry, matchEnd := node.entry, end
 
-	if strings.HasSuffix(s, sepNl) {
-		sep = sepNl
-	} else if strings.HasSuffix(s, sepSpace) {
-		sep = sepSpace
-	} else if strings.HasSuffix(s, sepCr) {
-		sep = sepSpace
-	} else {
-		sep = ""
-	}
+		// Follow the phrase for as long as the next words continue it.
+		for node.next != nil {
+			nstart, nend := nextWord(s, end)
+
+			if nstart < 0 {
+				break
+			}
+
+			if node = node.next[separator(s[end:nstart])]; node == nil {
+				break
+			}
+
+			if node = lookup(node.next, s[nstart:nend]); node == nil {
+				break
+			}
+
+			end = nend
+
+			if node.entry != "" {
+				entry, matchEnd = node.entry, end
+			}
+		}
+
+		if entry == "" {
+			continue
+		}
 
-	buf.WriteString(p.replace(s[start : len(s)-len(sep)]))
-	buf.WriteString(sep)
+		if !fn(start, matchEnd, entry) {
+			return
+		}
+
+		i = matchEnd
+	}
 }
 
-func (p *SetReplacer) replace(word string) string {
-	if stars, ok := p.repl[strings.ToLower(word)]; ok {
-		return stars
+// lookup returns the node of word in m ignoring case. Short words are lowered
+// into a stack buffer to avoid allocating.
+func lookup(m map[string]*setNode, word string) *setNode {
+	var buf [64]byte
+
+	if len(word) > len(buf) {
+		return m[lower(word)]
 	}
 
-	return word
+	return m[string(appendLower(buf[:0], word))]
 }
@@ -0,0 +1,117 @@
+package wordfilter
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// StreamWindow is the amount of text held back while streaming, so that a
+// match which spans the end of a chunk is found whole. Matches longer than
+// the window, such as a pattern matching 64 KiB of letters, may be split.
+const StreamWindow = 64 << 10
+
+// ReplaceStream copies src to dst where each word in the text that matches a
+// word in the blacklist of r is replaced by the mask. The text is read in
+// chunks and masked as ReplaceWith would mask the whole text.
+func ReplaceStream(dst io.Writer, src io.Reader, r Replacer, m Masker) error {
+	return maskStream(dst, src, r.Matches, m, StreamWindow)
+}
+
+// SanitizeStream copies src to dst where blacklisted words are masked
+// according to opts, as SanitizeWith would mask the whole text.
+func (w *Wordfilter) SanitizeStream(dst io.Writer, src io.Reader, opts *Options) error {
+	matches := func(v string) []Match {
+		return w.matches(v, opts)
+	}
+
+	m := w.mask(opts)
+
+	if w.Observer == nil {
+		return maskStream(dst, src, matches, m, StreamWindow)
+	}
+
+	// Matches are found again when they are held back with the window,
+	// so they are counted when masked.
+	n := 0
+	err := maskStream(dst, src, matches, counted(m, &n), StreamWindow)
+	w.Observer.Matched(n)
+	return err
+}
+
+// maskStream copies src to dst, masking the matches found in the text. The
+// text is buffered up to twice the window; the part before the last window
+// is written once the matches which cross into the window are known.
+func maskStream(dst io.Writer, src io.Reader, matches func(v string) []Match, m Masker, window int) error {
+	sw := getStringWriter(dst)
+	buf := make([]byte, 0, 2*window)
+	eof := false
+
+	for {
+		for !eof && len(buf) < cap(buf) {
+			n, err := src.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		v := string(buf)
+		found := matches(v)
+
+		if eof {
+			_, err := sw.WriteString(maskMatches(v, found, m))
+			return err
+		}
+
+		cut, n := streamCut(v, found, window)
+
+		if _, err := sw.WriteString(maskMatches(v[:cut], found[:n], m)); err != nil {
+			return err
+		}
+
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+}
+
+// streamCut returns where v, which is followed by more text, can be split
+// so that no match of v crosses into the last window of v, and the number
+// of matches before the split. The split follows a space where possible, so
+// that a phrase or word is not cut in half.
+func streamCut(v string, matches []Match, window int) (cut, n int) {
+	cut = len(v) - window
+
+	for cut > 0 && !utf8.RuneStart(v[cut]) {
+		cut--
+	}
+
+	for i := cut; i > 0; {
+		r, size := utf8.DecodeLastRuneInString(v[:i])
+
+		if unicode.IsSpace(r) {
+			cut = i
+			break
+		}
+
+		i -= size
+	}
+
+	for n < len(matches) && matches[n].Start < cut {
+		if matches[n].End > cut {
+			// The match started before the window and may be extended
+			// by the text which follows; write it with the window.
+			if matches[n].Start > 0 {
+				return matches[n].Start, n
+			}
+
+			return matches[n].End, n + 1
+		}
+
+		n++
+	}
+
+	return cut, n
+}
@@ -1,12 +1,8 @@
 package wordfilter
 
 import (
-	"errors"
 	"io"
-	"strings"
 	"sync"
-
-	"github.com/simonz05/util/math"
 )
 
 // A thread-safe word filter
@@ -24,44 +20,67 @@ func NewStringReplacer() *StringReplacer {
 
 // reload wordlist
 func (p *StringReplacer) Reload(words []string) error {
-	repl, err := p.buildReplacer(words)
-
-	if err != nil {
-		return err
-	}
-
+	repl := p.buildReplacer(words)
 	p.replMu.Lock()
 	p.repl = repl
 	p.replMu.Unlock()
 	return nil
 }
 
-// Build string replacer from blacklist
-func (p *StringReplacer) buildReplacer(words []string) (*genericReplacer, error) {
-	var starindex int
-	n := len(words) * 2
-
-	if n == 0 {
-		return nil, errors.New("Got empty blacklist")
-	}
-
-	repl := make([]string, n)
+// Build string replacer from blacklist, mapping each word to the blacklist
+// entry.
+func (p *StringReplacer) buildReplacer(words []string) *genericReplacer {
+	repl := make([]string, len(words)*2)
 
 	for i, w := range words {
 		repl[i*2] = w
-		starindex = math.IntMin(len(w), len(starmap)-1)
-		repl[i*2+1] = starmap[starindex]
+		repl[i*2+1] = w
 	}
 
-	return makeGenericReplacer(repl), nil
+	return makeGenericReplacer(repl)
 }
 
 // Returns a copy of string v where each word in the text that matches a word
 // in the blacklist is replaced by ****.
 func (p *StringReplacer) Replace(v string) string {
+	return p.ReplaceWith(v, Stars)
+}
+
+// Returns a copy of string v where each word in the text that matches a word
+// in the blacklist is replaced by the mask.
+func (p *StringReplacer) ReplaceWith(v string, m Masker) string {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	return p.repl.Replace(v, m)
+}
+
+// Returns the substrings of v which match a word in the blacklist.
+func (p *StringReplacer) Matches(v string) []Match {
+	p.replMu.RLock()
+	defer p.replMu.RUnlock()
+	var matches []Match
+
+	p.repl.scan(v, func(start, end int, entry string) bool {
+		matches = append(matches, Match{Start: start, End: end, Text: v[start:end], Entry: entry})
+		return true
+	})
+
+	return runeOffsets(v, matches)
+}
+
+// Reports whether v contains a word in the blacklist. It returns at the
+// first match.
+func (p *StringReplacer) Contains(v string) bool {
 	p.replMu.RLock()
 	defer p.replMu.RUnlock()
-	return p.repl.Replace(v)
+	found := false
+
+	p.repl.scan(v, func(start, end int, entry string) bool {
+		found = true
+		return false
+	})
+
+	return found
 }
 
 // Copyright 2011 The Go Authors. All rights reserved.
@@ -208,7 +227,7 @@ func (r *genericReplacer) lookup(s string, ignoreRoot bool) (val string, keylen
 			node = node.table[index]
 			s = s[1:]
 			n++
-		} else if node.prefix != "" && strings.HasPrefix(s, node.prefix) {
+		} else if node.prefix != "" && hasPrefixFold(s, node.prefix) {
 			n += len(node.prefix)
 			s = s[len(node.prefix):]
 			node = node.next
@@ -219,6 +238,24 @@ func (r *genericReplacer) lookup(s string, ignoreRoot bool) (val string, keylen
 	return
 }
 
+// hasPrefixFold reports whether s begins with the lower case prefix, ignoring
+// ASCII case in s.
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // genericReplacer is the fully generic algorithm.
 // It's used as a fallback when nothing faster can be used.
 type genericReplacer struct {
@@ -233,8 +270,8 @@ type genericReplacer struct {
 func makeGenericReplacer(oldnew []string) *genericReplacer {
 	r := new(genericReplacer)
 
-	for i := 0; i < len(oldnew); i++ {
-		oldnew[i] = strings.ToLower(oldnew[i])
+	for i := 0; i < len(oldnew); i += 2 {
+		oldnew[i] = lower(oldnew[i])
 	}
 
 	// Find each byte used, then assign them each an index.
@@ -258,6 +295,12 @@ func makeGenericReplacer(oldnew []string) *genericReplacer {
 			index++
 		}
 	}
+	// Upper case ASCII bytes share the index of the lower case byte, which
+	// makes lookups ignore ASCII case without lowering the input.
+	for c := 'A'; c <= 'Z'; c++ {
+		r.mapping[c] = r.mapping[c+'a'-'A']
+	}
+
 	// Ensure root node uses a lookup table (for performance).
 	r.root.table = make([]*trieNode, r.tableSize)
 
@@ -267,40 +310,62 @@ func makeGenericReplacer(oldnew []string) *genericReplacer {
 	return r
 }
 
-func (r *genericReplacer) Replace(s string) string {
+func (r *genericReplacer) Replace(s string, m Masker) string {
 	buf := make(appendSliceWriter, 0, len(s))
-	r.WriteString(&buf, s)
+	r.WriteString(&buf, s, m)
 	return string(buf)
 }
 
-func (r *genericReplacer) WriteString(w io.Writer, s string) (n int, err error) {
+func (r *genericReplacer) WriteString(w io.Writer, s string, m Masker) (n int, err error) {
 	sw := getStringWriter(w)
 	var last, wn int
+
+	r.scan(s, func(start, end int, val string) bool {
+		wn, err = sw.WriteString(s[last:start])
+		n += wn
+		if err != nil {
+			return false
+		}
+		wn, err = sw.WriteString(m.Mask(s[start:end]))
+		n += wn
+		if err != nil {
+			return false
+		}
+		last = end
+		return true
+	})
+
+	if err != nil {
+		return
+	}
+	if last != len(s) {
+		wn, err = sw.WriteString(s[last:])
+		n += wn
+	}
+	return
+}
+
+// scan calls fn with the byte offsets and value of each non-empty key found
+// in s. Scanning stops when fn returns false.
+func (r *genericReplacer) scan(s string, fn func(start, end int, val string) bool) {
+	// ASCII case is ignored by the lookup, so only non-ASCII text has to be
+	// lowered.
+	l := s
+	if !isASCII(s) {
+		l = lower(s)
+	}
 	var prevMatchEmpty bool
 	for i := 0; i <= len(s); {
 		// Ignore the empty match iff the previous loop found the empty match.
-		val, keylen, match := r.lookup(strings.ToLower(s[i:]), prevMatchEmpty)
+		val, keylen, match := r.lookup(l[i:], prevMatchEmpty)
 		prevMatchEmpty = match && keylen == 0
 		if match {
-			wn, err = sw.WriteString(s[last:i])
-			n += wn
-			if err != nil {
-				return
-			}
-			wn, err = sw.WriteString(val)
-			n += wn
-			if err != nil {
+			if keylen > 0 && !fn(i, i+keylen, val) {
 				return
 			}
 			i += keylen
-			last = i
 			continue
 		}
 		i++
 	}
-	if last != len(s) {
-		wn, err = sw.WriteString(s[last:])
-		n += wn
-	}
-	return
 }
@@ -0,0 +1,125 @@
+package wordfilter
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// isWordRune reports whether r is part of a word: letters, digits, combining
+// marks and connector punctuation such as _.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || unicode.Is(unicode.Pc, r)
+}
+
+// isMidLetter reports whether r joins two letters into a single word, as the
+// apostrophe in "don't".
+func isMidLetter(r rune) bool {
+	return r == '\'' || r == '’' || r == '·'
+}
+
+// isMidNum reports whether r joins two digits into a single word, as in
+// "3.14" or "1,000".
+func isMidNum(r rune) bool {
+	return r == '.' || r == ',' || r == '\''
+}
+
+// words calls fn with the byte offsets of each word in s. Word boundaries
+// follow the rules of Unicode text segmentation (UAX #29) in simplified form:
+// a word is a run of letters, digits and marks, where an apostrophe between
+// letters or a separator between digits does not break the word. Everything
+// else, such as whitespace and punctuation, separates words. Scanning stops
+// when fn returns false.
+func words(s string, fn func(start, end int) bool) {
+	for i := 0; ; {
+		start, end := nextWord(s, i)
+
+		if start < 0 || !fn(start, end) {
+			return
+		}
+
+		i = end
+	}
+}
+
+// nextWord returns the byte offsets of the first word in s which starts at or
+// after i, or -1, -1 if there is none.
+func nextWord(s string, i int) (start, end int) {
+	start = -1
+	var prev rune
+
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 && joins(prev, r, s[i+n:]) {
+			// part of the word
+		} else if start >= 0 {
+			return start, i
+		}
+
+		prev = r
+		i += n
+	}
+
+	if start >= 0 {
+		return start, len(s)
+	}
+
+	return -1, -1
+}
+
+// separator returns the text between two words in the form it is matched in:
+// lower case with each run of whitespace collapsed to a single space, so that
+// a phrase matches regardless of the amount of whitespace between its words.
+func separator(s string) string {
+	space := true
+
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			space = false
+			break
+		}
+	}
+
+	if space {
+		return " "
+	}
+
+	l := lower(s)
+	buf := make([]byte, 0, len(l))
+	inSpace := false
+
+	for i := 0; i < len(l); {
+		r, n := utf8.DecodeRuneInString(l[i:])
+
+		if !unicode.IsSpace(r) {
+			buf = append(buf, l[i:i+n]...)
+		} else if !inSpace {
+			buf = append(buf, ' ')
+		}
+
+		inSpace = unicode.IsSpace(r)
+		i += n
+	}
+
+	return string(buf)
+}
+
+// joins reports whether r, which follows prev and is followed by rest, is
+// inside a word.
+func joins(prev, r rune, rest string) bool {
+	next, _ := utf8.DecodeRuneInString(rest)
+
+	if unicode.IsLetter(prev) && unicode.IsLetter(next) {
+		return isMidLetter(r)
+	}
+
+	if unicode.IsNumber(prev) && unicode.IsNumber(next) {
+		return isMidNum(r)
+	}
+
+	return false
+}
@@ -1,6 +1,10 @@
 package wordfilter
 
 import (
+	"io"
+	"sync"
+	"time"
+
 	"github.com/simonz05/profanity/wordlist"
 )
 
@@ -10,19 +14,94 @@ import (
 type ProfanityFilter interface {
 	wordlist.Wordlist
 	Sanitize(v string) string
+	SanitizeWith(v string, opts *Options) string
+	SanitizeStream(dst io.Writer, src io.Reader, opts *Options) error
+	Matches(v string) []Match
+	MatchesWith(v string, opts *Options) []Match
+	Contains(v string) bool
+	ContainsWith(v string, opts *Options) bool
+	Entry(entry string) wordlist.Entry
 	Reload() error
+	Allowlist() wordlist.Wordlist
+}
+
+// Options control a single sanitize call.
+type Options struct {
+	// Mask is drawn in place of each blacklisted word. Nil means the
+	// filter's default mask.
+	Mask Masker
+	// MinSeverity ignores entries below the severity. Unrated entries are
+	// always included.
+	MinSeverity wordlist.Severity
+	// Categories, if non-empty, ignores entries without one of the
+	// categories.
+	Categories []string
+}
+
+// filtered reports whether opts ignore some entries.
+func (opts *Options) filtered() bool {
+	return opts != nil && (opts.MinSeverity > wordlist.Unrated || len(opts.Categories) > 0)
+}
+
+// includes reports whether the entry e is included by opts.
+func (opts *Options) includes(e wordlist.Entry) bool {
+	if e.Severity != wordlist.Unrated && e.Severity < opts.MinSeverity {
+		return false
+	}
+
+	if len(opts.Categories) == 0 {
+		return true
+	}
+
+	for _, c := range e.Categories {
+		for _, want := range opts.Categories {
+			if c == want {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // Wordfilter implements the ProfanityFilter interface.
 type Wordfilter struct {
 	List     wordlist.Wordlist
 	Replacer Replacer
+	// Masker is the default mask. Nil means Stars.
+	Masker Masker
+	// Allow is the allowlist. A match which falls entirely within an
+	// allowlisted word, such as "cunt" in "Scunthorpe", is not a match. Nil
+	// means no allowlist.
+	Allow wordlist.Wordlist
+	// Base is an inherited wordlist, such as a list shared by several
+	// tenants, whose words are filtered as well as those of List. It is not
+	// changed through the filter. Nil means no inherited words.
+	Base wordlist.Wordlist
+	// Observer, if non-nil, is notified of matches and reloads, such as to
+	// export metrics.
+	Observer Observer
+
+	allow   *AhoCorasickReplacer      // nil when the allowlist is empty
+	entries map[string]wordlist.Entry // blacklist entry to its severity and categories
+	mu      sync.RWMutex              // allow and entries locker
+}
+
+// An Observer is notified of the work of a Wordfilter.
+type Observer interface {
+	// Matched is called with the number of matches masked by a sanitize
+	// call or returned by a matches call. Contains calls are not counted.
+	Matched(n int)
+	// Reloaded is called after the blacklist is loaded into the replacer,
+	// with the number of entries and the time it took.
+	Reloaded(entries int, d time.Duration)
 }
 
 func NewWordfilter(list wordlist.Wordlist) *Wordfilter {
 	return &Wordfilter{
 		List:     list,
-		Replacer: NewSetReplacer(),
+		Replacer: NewPatternReplacer(NewSetReplacer(), nil, true),
+		Allow:    wordlist.NewMemoryWordlist(),
 	}
 }
 
@@ -36,8 +115,13 @@ func (w *Wordfilter) Get(count, offset int) ([]string, error) {
 	return w.List.Get(count, offset)
 }
 
-// Add or overwrite words
+// Add or overwrite words. Invalid patterns are rejected with a
+// *PatternError before the wordlist is changed.
 func (w *Wordfilter) Set(words []string) error {
+	if err := w.validate(words); err != nil {
+		return err
+	}
+
 	if err := w.List.Set(words); err != nil {
 		return err
 	}
@@ -45,6 +129,39 @@ func (w *Wordfilter) Set(words []string) error {
 	return w.Reload()
 }
 
+// Return all entries with their severity and categories
+func (w *Wordfilter) Entries() ([]wordlist.Entry, error) {
+	return w.List.Entries()
+}
+
+// Add or overwrite entries. Invalid patterns are rejected with a
+// *PatternError before the wordlist is changed.
+func (w *Wordfilter) SetEntries(entries []wordlist.Entry) error {
+	if err := w.validate(entryWords(entries)); err != nil {
+		return err
+	}
+
+	if err := w.List.SetEntries(entries); err != nil {
+		return err
+	}
+
+	return w.Reload()
+}
+
+// Replace wordlist with `entries`. Invalid patterns are rejected with a
+// *PatternError before the wordlist is changed.
+func (w *Wordfilter) ReplaceEntries(entries []wordlist.Entry) error {
+	if err := w.validate(entryWords(entries)); err != nil {
+		return err
+	}
+
+	if err := w.List.ReplaceEntries(entries); err != nil {
+		return err
+	}
+
+	return w.Reload()
+}
+
 // Delete words
 func (w *Wordfilter) Delete(words []string) error {
 	if err := w.List.Delete(words); err != nil {
@@ -54,8 +171,13 @@ func (w *Wordfilter) Delete(words []string) error {
 	return w.Reload()
 }
 
-// Replace wordlist with `words`
+// Replace wordlist with `words`. Invalid patterns are rejected with a
+// *PatternError before the wordlist is changed.
 func (w *Wordfilter) Replace(words []string) error {
+	if err := w.validate(words); err != nil {
+		return err
+	}
+
 	if err := w.List.Replace(words); err != nil {
 		return err
 	}
@@ -63,20 +185,87 @@ func (w *Wordfilter) Replace(words []string) error {
 	return w.Reload()
 }
 
+// validate returns an error for the first pattern in words which the
+// replacer would reject.
+func (w *Wordfilter) validate(words []string) error {
+	if v, ok := w.Replacer.(interface{ Validate([]string) error }); ok {
+		return v.Validate(words)
+	}
+
+	return validatePatterns(words)
+}
+
 func (w *Wordfilter) Reload() error {
-	cnt, err := w.List.Count()
+	if err := w.reloadAllow(); err != nil {
+		return err
+	}
+
+	var lists wordlist.Wordlist = w.List
+
+	if w.Base != nil {
+		lists = wordlist.NewUnionWordlist(w.List, w.Base)
+	}
+
+	list, err := lists.Entries()
 
 	if err != nil {
 		return err
 	}
 
-	strings, err := w.List.Get(cnt, 0)
+	entries := make(map[string]wordlist.Entry, len(list))
 
-	if err != nil {
+	for _, e := range list {
+		entries[e.Word] = e
+	}
+
+	start := time.Now()
+
+	if err := w.Replacer.Reload(entryWords(list)); err != nil {
 		return err
 	}
 
-	return w.Replacer.Reload(strings)
+	if w.Observer != nil {
+		w.Observer.Reloaded(len(list), time.Since(start))
+	}
+
+	w.mu.Lock()
+	w.entries = entries
+	w.mu.Unlock()
+	return nil
+}
+
+// entryWords returns the words of entries.
+func entryWords(entries []wordlist.Entry) []string {
+	res := make([]string, len(entries))
+
+	for i, e := range entries {
+		res[i] = e.Word
+	}
+
+	return res
+}
+
+// reloadAllow rebuilds the allowlist matcher.
+func (w *Wordfilter) reloadAllow() error {
+	var allow *AhoCorasickReplacer
+
+	if w.Allow != nil {
+		entries, err := w.Allow.Entries()
+
+		if err != nil {
+			return err
+		}
+
+		if len(entries) > 0 {
+			allow = NewAhoCorasickReplacer()
+			allow.Reload(entryWords(entries))
+		}
+	}
+
+	w.mu.Lock()
+	w.allow = allow
+	w.mu.Unlock()
+	return nil
 }
 
 // Reset the wordlist
@@ -85,10 +274,212 @@ func (w *Wordfilter) Empty() error {
 		return err
 	}
 
-	return w.Replacer.Reload([]string{})
+	return w.Reload()
 }
 
-// Reset the wordlist
+// Return a copy of v where blacklisted words are masked
 func (w *Wordfilter) Sanitize(v string) string {
-	return w.Replacer.Replace(v)
+	return w.SanitizeWith(v, nil)
+}
+
+// Return a copy of v where blacklisted words are masked according to opts
+func (w *Wordfilter) SanitizeWith(v string, opts *Options) string {
+	m := w.mask(opts)
+
+	if w.allowlist() != nil || opts.filtered() {
+		return maskMatches(v, w.MatchesWith(v, opts), m)
+	}
+
+	if w.Observer == nil {
+		return w.Replacer.ReplaceWith(v, m)
+	}
+
+	n := 0
+	v = w.Replacer.ReplaceWith(v, counted(m, &n))
+	w.Observer.Matched(n)
+	return v
+}
+
+// counted returns a mask which counts the words it masks in n.
+func counted(m Masker, n *int) Masker {
+	return MaskerFunc(func(word string) string {
+		*n++
+		return m.Mask(word)
+	})
+}
+
+// mask returns the mask of opts, or the filter's default mask.
+func (w *Wordfilter) mask(opts *Options) Masker {
+	if opts != nil && opts.Mask != nil {
+		return opts.Mask
+	}
+
+	if w.Masker != nil {
+		return w.Masker
+	}
+
+	return Stars
+}
+
+// Return the blacklisted words found in v
+func (w *Wordfilter) Matches(v string) []Match {
+	return w.MatchesWith(v, nil)
+}
+
+// Return the blacklisted words found in v which are included by opts
+func (w *Wordfilter) MatchesWith(v string, opts *Options) []Match {
+	matches := w.matches(v, opts)
+
+	if w.Observer != nil {
+		w.Observer.Matched(len(matches))
+	}
+
+	return matches
+}
+
+// matches returns the matches of MatchesWith without notifying the observer.
+func (w *Wordfilter) matches(v string, opts *Options) []Match {
+	matches := w.Replacer.Matches(v)
+
+	if allow := w.allowlist(); allow != nil && len(matches) > 0 {
+		matches = allowMatches(matches, allow.Matches(v))
+	}
+
+	if opts.filtered() {
+		out := matches[:0]
+
+		for _, m := range matches {
+			if opts.includes(w.Entry(m.Entry)) {
+				out = append(out, m)
+			}
+		}
+
+		matches = out
+	}
+
+	return matches
+}
+
+// Report whether v contains a blacklisted word
+func (w *Wordfilter) Contains(v string) bool {
+	return w.ContainsWith(v, nil)
+}
+
+// Report whether v contains a blacklisted word which is included by opts
+func (w *Wordfilter) ContainsWith(v string, opts *Options) bool {
+	if w.allowlist() == nil && !opts.filtered() {
+		return w.Replacer.Contains(v)
+	}
+
+	return len(w.MatchesWith(v, opts)) > 0
+}
+
+// Return the severity and categories of the blacklist entry
+func (w *Wordfilter) Entry(entry string) wordlist.Entry {
+	w.mu.RLock()
+	e, ok := w.entries[entry]
+	w.mu.RUnlock()
+
+	if !ok {
+		e.Word = entry
+	}
+
+	return e
+}
+
+func (w *Wordfilter) allowlist() *AhoCorasickReplacer {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.allow
+}
+
+// allowMatches removes the matches which fall entirely within an allowed
+// word. Both are ordered by offset.
+func allowMatches(matches, allowed []Match) []Match {
+	out := matches[:0]
+	j := 0
+
+	for _, m := range matches {
+		for j < len(allowed) && allowed[j].End <= m.Start {
+			j++
+		}
+
+		if j < len(allowed) && allowed[j].Start <= m.Start && m.End <= allowed[j].End {
+			continue
+		}
+
+		out = append(out, m)
+	}
+
+	return out
+}
+
+// Return the allowlist. Changes made through it take effect immediately.
+func (w *Wordfilter) Allowlist() wordlist.Wordlist {
+	return &allowlist{w}
+}
+
+// allowlist rebuilds the allowlist matcher of a Wordfilter after each change.
+type allowlist struct {
+	w *Wordfilter
+}
+
+func (a *allowlist) Count() (int, error) {
+	return a.w.Allow.Count()
+}
+
+func (a *allowlist) Get(count, offset int) ([]string, error) {
+	return a.w.Allow.Get(count, offset)
+}
+
+func (a *allowlist) Set(words []string) error {
+	if err := a.w.Allow.Set(words); err != nil {
+		return err
+	}
+
+	return a.w.reloadAllow()
+}
+
+func (a *allowlist) Entries() ([]wordlist.Entry, error) {
+	return a.w.Allow.Entries()
+}
+
+func (a *allowlist) SetEntries(entries []wordlist.Entry) error {
+	if err := a.w.Allow.SetEntries(entries); err != nil {
+		return err
+	}
+
+	return a.w.reloadAllow()
+}
+
+func (a *allowlist) ReplaceEntries(entries []wordlist.Entry) error {
+	if err := a.w.Allow.ReplaceEntries(entries); err != nil {
+		return err
+	}
+
+	return a.w.reloadAllow()
+}
+
+func (a *allowlist) Delete(words []string) error {
+	if err := a.w.Allow.Delete(words); err != nil {
+		return err
+	}
+
+	return a.w.reloadAllow()
+}
+
+func (a *allowlist) Replace(words []string) error {
+	if err := a.w.Allow.Replace(words); err != nil {
+		return err
+	}
+
+	return a.w.reloadAllow()
+}
+
+func (a *allowlist) Empty() error {
+	if err := a.w.Allow.Empty(); err != nil {
+		return err
+	}
+
+	return a.w.reloadAllow()
 }
@@ -0,0 +1,97 @@
+package wordlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Severity is how offensive a word is. Redis stores it as the score of the
+// word.
+type Severity int
+
+const (
+	// Unrated is the severity of words added without one.
+	Unrated Severity = iota
+	Mild
+	Strong
+	Severe
+)
+
+var severityNames = []string{"unrated", "mild", "strong", "severe"}
+
+// ParseSeverity parses the name of a severity.
+func ParseSeverity(s string) (Severity, error) {
+	for i, name := range severityNames {
+		if strings.EqualFold(s, name) {
+			return Severity(i), nil
+		}
+	}
+
+	return Unrated, fmt.Errorf("unknown severity %q", s)
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+
+	return severityNames[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	v, err := ParseSeverity(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	*s = v
+	return nil
+}
+
+// Entry is a word with its severity and category tags, such as sexual,
+// slur, violence or drugs.
+type Entry struct {
+	Word       string   `json:"word"`
+	Severity   Severity `json:"severity,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+// entry is Entry without its JSON methods.
+type entry Entry
+
+// MarshalJSON encodes an entry without severity and categories as the plain
+// word.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	if e.Severity == Unrated && len(e.Categories) == 0 {
+		return json.Marshal(e.Word)
+	}
+
+	return json.Marshal(entry(e))
+}
+
+// UnmarshalJSON decodes an entry or a plain word.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*e = Entry{}
+		return json.Unmarshal(data, &e.Word)
+	}
+
+	return json.Unmarshal(data, (*entry)(e))
+}
+
+// entries returns unrated entries of words.
+func entries(words []string) []Entry {
+	res := make([]Entry, len(words))
+
+	for i, w := range words {
+		res[i].Word = w
+	}
+
+	return res
+}
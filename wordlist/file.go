@@ -0,0 +1,374 @@
+package wordlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultCompactEvery is the number of journal entries after which a
+// FileWordlist compacts its journal into a new snapshot.
+const DefaultCompactEvery = 1000
+
+const (
+	opSet     = "set"
+	opDelete  = "delete"
+	opReplace = "replace"
+	opEmpty   = "empty"
+)
+
+// journalEntry is a single operation in the append-only journal. Set and
+// replace carry either words or entries.
+type journalEntry struct {
+	Op      string   `json:"op"`
+	Words   []string `json:"words,omitempty"`
+	Entries []Entry  `json:"entries,omitempty"`
+}
+
+// FileWordlist is a file backed wordlist implementation. The list is kept in
+// memory and persisted as a snapshot and an append-only journal of
+// operations. The journal is replayed on open and compacted into a new
+// snapshot every CompactEvery operations.
+type FileWordlist struct {
+	// CompactEvery is the number of journal entries which triggers a
+	// compaction. Zero means DefaultCompactEvery.
+	CompactEvery int
+
+	snapshotPath string
+	journalPath  string
+	list         *MemoryWordlist
+	journal      journalFile
+	entries      int
+	loaded       bool
+	mu           sync.Mutex
+}
+
+// journalFile is the open journal, an *os.File.
+type journalFile interface {
+	io.WriteCloser
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// NewFileWordlist returns a wordlist stored in dir. The files are opened and
+// recovered on first use.
+func NewFileWordlist(dir, lang string) *FileWordlist {
+	name := url.QueryEscape(lang)
+	return &FileWordlist{
+		snapshotPath: filepath.Join(dir, name+".snapshot"),
+		journalPath:  filepath.Join(dir, name+".journal"),
+		list:         NewMemoryWordlist(),
+	}
+}
+
+func (w *FileWordlist) Count() (int, error) {
+	if err := w.load(); err != nil {
+		return 0, err
+	}
+	return w.list.Count()
+}
+
+func (w *FileWordlist) Get(count, offset int) ([]string, error) {
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w.list.Get(count, offset)
+}
+
+func (w *FileWordlist) Set(words []string) error {
+	return w.write(&journalEntry{Op: opSet, Words: words})
+}
+
+func (w *FileWordlist) Entries() ([]Entry, error) {
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w.list.Entries()
+}
+
+func (w *FileWordlist) SetEntries(entries []Entry) error {
+	return w.write(&journalEntry{Op: opSet, Entries: entries})
+}
+
+func (w *FileWordlist) ReplaceEntries(entries []Entry) error {
+	return w.write(&journalEntry{Op: opReplace, Entries: entries})
+}
+
+func (w *FileWordlist) Delete(words []string) error {
+	return w.write(&journalEntry{Op: opDelete, Words: words})
+}
+
+func (w *FileWordlist) Replace(words []string) error {
+	return w.write(&journalEntry{Op: opReplace, Words: words})
+}
+
+func (w *FileWordlist) Empty() error {
+	return w.write(&journalEntry{Op: opEmpty})
+}
+
+// Close closes the journal file.
+func (w *FileWordlist) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.loaded = false
+
+	if w.journal == nil {
+		return nil
+	}
+
+	err := w.journal.Close()
+	w.journal = nil
+	return err
+}
+
+// load recovers the list from disk unless it is already loaded.
+func (w *FileWordlist) load() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.loadLocked()
+}
+
+func (w *FileWordlist) loadLocked() error {
+	if w.loaded {
+		return nil
+	}
+
+	entries, err := readSnapshot(w.snapshotPath)
+
+	if err != nil {
+		return err
+	}
+
+	w.list.ReplaceEntries(entries)
+
+	if err := w.replay(); err != nil {
+		return err
+	}
+
+	w.loaded = true
+	fi, err := os.Stat(w.journalPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The journal of an earlier run, which may end in a truncated entry, is
+	// compacted before anything is appended to it.
+	if fi.Size() > 0 {
+		if err := w.openJournal(); err != nil {
+			return err
+		}
+
+		return w.compact()
+	}
+
+	return nil
+}
+
+// openJournal opens the journal for appending. The directory and the journal
+// are created on the first write, so reading a missing list creates no files.
+func (w *FileWordlist) openJournal() error {
+	if w.journal != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.journalPath), 0755); err != nil {
+		return err
+	}
+
+	journal, err := os.OpenFile(w.journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	w.journal = journal
+	return nil
+}
+
+// replay applies the journal to the in-memory list. A truncated last entry,
+// left by an interrupted write, is ignored.
+func (w *FileWordlist) replay() error {
+	f, err := os.Open(w.journalPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		e := new(journalEntry)
+
+		if err := json.Unmarshal(line, e); err != nil {
+			return fmt.Errorf("%s: corrupt journal entry %d: %v", w.journalPath, n, err)
+		}
+
+		if err := w.apply(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *FileWordlist) apply(e *journalEntry) error {
+	switch e.Op {
+	case opSet:
+		if e.Entries != nil {
+			return w.list.SetEntries(e.Entries)
+		}
+		return w.list.Set(e.Words)
+	case opDelete:
+		return w.list.Delete(e.Words)
+	case opReplace:
+		if e.Entries != nil {
+			return w.list.ReplaceEntries(e.Entries)
+		}
+		return w.list.Replace(e.Words)
+	case opEmpty:
+		return w.list.Empty()
+	}
+
+	return fmt.Errorf("%s: unknown journal op %q", w.journalPath, e.Op)
+}
+
+// write appends e to the journal and applies it to the list.
+func (w *FileWordlist) write(e *journalEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.loadLocked(); err != nil {
+		return err
+	}
+
+	if err := w.openJournal(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	fi, err := w.journal.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	// A failed write may leave part of the entry, which the next entry
+	// would be appended to, so the journal is cut back to its last entry.
+	_, err = w.journal.Write(append(data, '\n'))
+
+	if err == nil {
+		err = w.journal.Sync()
+	}
+
+	if err != nil {
+		if terr := w.journal.Truncate(fi.Size()); terr != nil {
+			return fmt.Errorf("%s: %v, and truncating the journal: %v", w.journalPath, err, terr)
+		}
+
+		return err
+	}
+
+	if err := w.apply(e); err != nil {
+		return err
+	}
+
+	w.entries++
+	compactEvery := w.CompactEvery
+
+	if compactEvery <= 0 {
+		compactEvery = DefaultCompactEvery
+	}
+
+	if w.entries >= compactEvery {
+		return w.compact()
+	}
+
+	return nil
+}
+
+// compact writes the current list to a new snapshot and truncates the
+// journal. The snapshot is replaced atomically, and replaying a journal which
+// is already part of the snapshot yields the same list, so a crash at any
+// point leaves a recoverable state.
+func (w *FileWordlist) compact() error {
+	if err := writeSnapshot(w.snapshotPath, w.list.all()); err != nil {
+		return err
+	}
+
+	if err := w.journal.Truncate(0); err != nil {
+		return err
+	}
+
+	w.entries = 0
+	return nil
+}
+
+// readSnapshot reads the entries of a snapshot. Entries without severity and
+// categories are stored as plain words.
+func readSnapshot(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	var entries []Entry
+
+	if err := json.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return entries, nil
+}
+
+func writeSnapshot(path string, entries []Entry) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(entries); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
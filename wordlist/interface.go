@@ -11,6 +11,15 @@ type Wordlist interface {
 	// Add or overwrite words
 	Set(words []string) error
 
+	// Return all entries with their severity and categories
+	Entries() ([]Entry, error)
+
+	// Add or overwrite entries
+	SetEntries(entries []Entry) error
+
+	// Replace wordlist with `entries`
+	ReplaceEntries(entries []Entry) error
+
 	// Delete words
 	Delete(words []string) error
 
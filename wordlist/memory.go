@@ -0,0 +1,169 @@
+package wordlist
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/simonz05/util/math"
+)
+
+// MemoryWordlist is a thread-safe in-memory wordlist implementation. Words are
+// kept sorted by severity and then by word, so that Get has the same ordering
+// and offset semantics as RedisWordlist.
+type MemoryWordlist struct {
+	words   []string
+	entries map[string]Entry
+	mu      sync.RWMutex
+}
+
+func NewMemoryWordlist() *MemoryWordlist {
+	return &MemoryWordlist{
+		entries: make(map[string]Entry),
+	}
+}
+
+func (w *MemoryWordlist) Count() (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.words), nil
+}
+
+func (w *MemoryWordlist) Get(count, offset int) ([]string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	starting_offset := math.IntMax(offset, 0)
+	ending_offset := math.IntMax((starting_offset+count)-1, 1)
+	return zrange(w.words, starting_offset, ending_offset), nil
+}
+
+func (w *MemoryWordlist) Set(words []string) error {
+	return w.SetEntries(entries(words))
+}
+
+func (w *MemoryWordlist) Entries() ([]Entry, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	res := make([]Entry, len(w.words))
+
+	for i, word := range w.words {
+		res[i] = w.entries[word]
+	}
+
+	return res, nil
+}
+
+func (w *MemoryWordlist) SetEntries(entries []Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, e := range entries {
+		w.insert(e)
+	}
+
+	return nil
+}
+
+func (w *MemoryWordlist) ReplaceEntries(entries []Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.words = nil
+	w.entries = make(map[string]Entry, len(entries))
+
+	for _, e := range entries {
+		w.insert(e)
+	}
+
+	return nil
+}
+
+func (w *MemoryWordlist) Delete(words []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, word := range words {
+		w.remove(word)
+	}
+
+	return nil
+}
+
+func (w *MemoryWordlist) Replace(words []string) error {
+	return w.ReplaceEntries(entries(words))
+}
+
+func (w *MemoryWordlist) Empty() error {
+	w.mu.Lock()
+	w.words = nil
+	w.entries = make(map[string]Entry)
+	w.mu.Unlock()
+	return nil
+}
+
+// all returns a copy of all entries.
+func (w *MemoryWordlist) all() []Entry {
+	res, _ := w.Entries()
+	return res
+}
+
+// insert adds or overwrites the entry.
+func (w *MemoryWordlist) insert(e Entry) {
+	w.remove(e.Word)
+	i := w.search(e.Severity, e.Word)
+	w.words = append(w.words, "")
+	copy(w.words[i+1:], w.words[i:])
+	w.words[i] = e.Word
+	w.entries[e.Word] = e
+}
+
+// remove deletes word if present.
+func (w *MemoryWordlist) remove(word string) {
+	e, ok := w.entries[word]
+
+	if !ok {
+		return
+	}
+
+	i := w.search(e.Severity, word)
+	w.words = append(w.words[:i], w.words[i+1:]...)
+	delete(w.entries, word)
+}
+
+// search returns the index of the first word which sorts at or after word
+// with severity s.
+func (w *MemoryWordlist) search(s Severity, word string) int {
+	return sort.Search(len(w.words), func(i int) bool {
+		other := w.words[i]
+
+		if t := w.entries[other].Severity; t != s {
+			return t > s
+		}
+
+		return other >= word
+	})
+}
+
+// zrange returns a copy of the inclusive range start..stop of the sorted
+// words using the index semantics of the redis ZRANGE command.
+func zrange(words []string, start, stop int) []string {
+	n := len(words)
+
+	if start < 0 {
+		start = math.IntMax(n+start, 0)
+	}
+
+	if stop < 0 {
+		stop = n + stop
+	}
+
+	if stop >= n {
+		stop = n - 1
+	}
+
+	if start > stop || start >= n {
+		return []string{}
+	}
+
+	res := make([]string, stop-start+1)
+	copy(res, words[start:stop+1])
+	return res
+}
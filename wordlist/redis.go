@@ -2,24 +2,54 @@ package wordlist
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/simonz05/profanity/db"
 	"github.com/simonz05/util/math"
 )
 
-// RedisWordlist is a redis backed wordlist implementation.
+// RedisWordlist is a redis backed wordlist implementation. Words are stored
+// in a sorted set scored by severity, and their categories in a companion
+// hash.
 type RedisWordlist struct {
-	lang string
-	key  string
-	conn db.Conn
+	lang   string
+	key    string
+	catKey string
+	conn   db.Conn
 }
 
 func NewRedisWordlist(conn db.Conn, lang string) *RedisWordlist {
+	return newRedisWordlist(conn, fmt.Sprintf("profanity:wordlist:%s", lang), lang)
+}
+
+// NewRedisAllowlist returns the allowlist of lang, which is stored under its
+// own key next to the blacklist.
+func NewRedisAllowlist(conn db.Conn, lang string) *RedisWordlist {
+	return newRedisWordlist(conn, fmt.Sprintf("profanity:allowlist:%s", lang), lang)
+}
+
+// NewRedisNamedWordlist returns the named list of lang, such as a list for a
+// single product which is combined with the default list.
+func NewRedisNamedWordlist(conn db.Conn, name, lang string) *RedisWordlist {
+	return newRedisWordlist(conn, fmt.Sprintf("profanity:list:%s:%s", name, lang), lang)
+}
+
+// NewRedisTenantWordlist returns list, one of the lists above, of tenant. The
+// lists of a tenant are stored under profanity:tenant:<tenant>, so that
+// tenants do not change each other's lists.
+func NewRedisTenantWordlist(list *RedisWordlist, tenant string) *RedisWordlist {
+	key := fmt.Sprintf("profanity:tenant:%s:%s", tenant, strings.TrimPrefix(list.key, "profanity:"))
+	return newRedisWordlist(list.conn, key, list.lang)
+}
+
+func newRedisWordlist(conn db.Conn, key, lang string) *RedisWordlist {
 	return &RedisWordlist{
-		lang: lang,
-		key:  fmt.Sprintf("profanity:wordlist:%s", lang),
-		conn: conn,
+		lang:   lang,
+		key:    key,
+		catKey: key + ":categories",
+		conn:   conn,
 	}
 }
 
@@ -38,17 +68,67 @@ func (w *RedisWordlist) Get(count, offset int) ([]string, error) {
 }
 
 func (w *RedisWordlist) Set(words []string) error {
+	return w.SetEntries(entries(words))
+}
+
+func (w *RedisWordlist) Entries() ([]Entry, error) {
+	conn := w.conn.Get()
+	defer conn.Close()
+	values, err := redis.Strings(conn.Do("ZRANGE", w.key, 0, -1, "WITHSCORES"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := redis.StringMap(conn.Do("HGETALL", w.catKey))
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Entry, 0, len(values)/2)
+
+	for i := 0; i+1 < len(values); i += 2 {
+		e := Entry{Word: values[i]}
+		score, _ := strconv.Atoi(values[i+1])
+		e.Severity = Severity(score)
+
+		if c := categories[e.Word]; c != "" {
+			e.Categories = strings.Split(c, ",")
+		}
+
+		res = append(res, e)
+	}
+
+	return res, nil
+}
+
+func (w *RedisWordlist) SetEntries(entries []Entry) error {
 	conn := w.conn.Get()
 	defer conn.Close()
 	conn.Send("MULTI")
 
-	for i := 0; i < len(words); i++ {
-		conn.Send("ZADD", w.key, 0, words[i])
+	for _, e := range entries {
+		conn.Send("ZADD", w.key, int(e.Severity), e.Word)
+
+		if len(e.Categories) > 0 {
+			conn.Send("HSET", w.catKey, e.Word, strings.Join(e.Categories, ","))
+		} else {
+			conn.Send("HDEL", w.catKey, e.Word)
+		}
 	}
+
 	_, err := conn.Do("EXEC")
 	return err
 }
 
+func (w *RedisWordlist) ReplaceEntries(entries []Entry) error {
+	if err := w.Empty(); err != nil {
+		return err
+	}
+	return w.SetEntries(entries)
+}
+
 func (w *RedisWordlist) Delete(words []string) error {
 	conn := w.conn.Get()
 	defer conn.Close()
@@ -56,6 +136,7 @@ func (w *RedisWordlist) Delete(words []string) error {
 
 	for i := 0; i < len(words); i++ {
 		conn.Send("ZREM", w.key, words[i])
+		conn.Send("HDEL", w.catKey, words[i])
 	}
 
 	_, err := conn.Do("EXEC")
@@ -63,15 +144,12 @@ func (w *RedisWordlist) Delete(words []string) error {
 }
 
 func (w *RedisWordlist) Replace(words []string) error {
-	if err := w.Empty(); err != nil {
-		return err
-	}
-	return w.Set(words)
+	return w.ReplaceEntries(entries(words))
 }
 
 func (w *RedisWordlist) Empty() error {
 	conn := w.conn.Get()
-	_, err := conn.Do("DEL", w.key)
+	_, err := conn.Do("DEL", w.key, w.catKey)
 	conn.Close()
 	return err
 }
@@ -0,0 +1,129 @@
+package wordlist
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/simonz05/util/math"
+)
+
+// ErrReadOnly is returned when changing a wordlist which can not be changed.
+var ErrReadOnly = errors.New("wordlist is read-only")
+
+// UnionWordlist is the read-only union of several wordlists. A word in more
+// than one list has the highest of its severities and all of its categories.
+type UnionWordlist struct {
+	Lists []Wordlist
+}
+
+func NewUnionWordlist(lists ...Wordlist) *UnionWordlist {
+	return &UnionWordlist{Lists: lists}
+}
+
+func (w *UnionWordlist) Count() (int, error) {
+	entries, err := w.Entries()
+	return len(entries), err
+}
+
+func (w *UnionWordlist) Get(count, offset int) ([]string, error) {
+	entries, err := w.Entries()
+
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]string, len(entries))
+
+	for i, e := range entries {
+		words[i] = e.Word
+	}
+
+	starting_offset := math.IntMax(offset, 0)
+	ending_offset := math.IntMax((starting_offset+count)-1, 1)
+	return zrange(words, starting_offset, ending_offset), nil
+}
+
+// Entries returns the merged entries ordered by severity and then by word.
+func (w *UnionWordlist) Entries() ([]Entry, error) {
+	merged := make(map[string]Entry)
+
+	for _, list := range w.Lists {
+		entries, err := list.Entries()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if prev, ok := merged[e.Word]; ok {
+				e = mergeEntries(prev, e)
+			}
+
+			merged[e.Word] = e
+		}
+	}
+
+	res := make([]Entry, 0, len(merged))
+
+	for _, e := range merged {
+		res = append(res, e)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Severity != res[j].Severity {
+			return res[i].Severity < res[j].Severity
+		}
+
+		return res[i].Word < res[j].Word
+	})
+
+	return res, nil
+}
+
+// mergeEntries returns the entry of a word in two lists.
+func mergeEntries(a, b Entry) Entry {
+	if b.Severity > a.Severity {
+		a.Severity = b.Severity
+	}
+
+	categories := append([]string(nil), a.Categories...)
+
+	for _, c := range b.Categories {
+		found := false
+
+		for _, have := range categories {
+			found = found || have == c
+		}
+
+		if !found {
+			categories = append(categories, c)
+		}
+	}
+
+	a.Categories = categories
+	return a
+}
+
+func (w *UnionWordlist) Set(words []string) error {
+	return ErrReadOnly
+}
+
+func (w *UnionWordlist) SetEntries(entries []Entry) error {
+	return ErrReadOnly
+}
+
+func (w *UnionWordlist) ReplaceEntries(entries []Entry) error {
+	return ErrReadOnly
+}
+
+func (w *UnionWordlist) Delete(words []string) error {
+	return ErrReadOnly
+}
+
+func (w *UnionWordlist) Replace(words []string) error {
+	return ErrReadOnly
+}
+
+func (w *UnionWordlist) Empty() error {
+	return ErrReadOnly
+}
@@ -1,7 +1,11 @@
 package wordlist
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
@@ -28,6 +32,9 @@ func (t *TestCase) String() string {
 }
 
 func initBackend() {
+	backends = append(backends, NewMemoryWordlist())
+	dir, _ := ioutil.TempDir("", "wordlist")
+	backends = append(backends, NewFileWordlist(dir, "en_US"))
 	c, _ := db.Open("redis://:@localhost:6379/15")
 	backends = append(backends, NewRedisWordlist(c, "en_US"))
 }
@@ -106,3 +113,243 @@ func TestWordlist(t *testing.T) {
 		}
 	}
 }
+
+func TestFileWordlistRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wordlist")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	list := NewFileWordlist(dir, "en_US")
+	list.CompactEvery = 3
+	list.Replace([]string{"c", "b"})
+	list.Set([]string{"a"})
+	list.Set([]string{"d"})
+	list.Delete([]string{"b"})
+	list.Close()
+
+	// simulate a write interrupted halfway through the journal entry
+	f, err := os.OpenFile(list.journalPath, os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteString(`{"op":"set","wor`)
+	f.Close()
+
+	list = NewFileWordlist(dir, "en_US")
+	exp := []string{"a", "c", "d"}
+
+	if values, err := list.Get(10, 0); !reflect.DeepEqual(values, exp) || err != nil {
+		t.Fatalf("expected %v got %v, err %v", exp, values, err)
+	}
+
+	if fi, err := os.Stat(list.journalPath); err != nil || fi.Size() != 0 {
+		t.Fatalf("expected compacted journal, err %v", err)
+	}
+
+	list.Set([]string{"e"})
+	list.Close()
+	list = NewFileWordlist(dir, "en_US")
+	exp = append(exp, "e")
+
+	if values, err := list.Get(10, 0); !reflect.DeepEqual(values, exp) || err != nil {
+		t.Fatalf("expected %v got %v, err %v", exp, values, err)
+	}
+}
+
+func TestFileWordlistMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wordlist")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// reading a missing list yields an empty list and creates no files
+	listDir := filepath.Join(dir, "lists", "kids")
+	list := NewFileWordlist(listDir, "en_US")
+
+	if n, err := list.Count(); n != 0 || err != nil {
+		t.Fatalf("expected 0, got %d, err %v", n, err)
+	}
+
+	if values, err := list.Get(10, 0); len(values) != 0 || err != nil {
+		t.Fatalf("expected no values, got %v, err %v", values, err)
+	}
+
+	if _, err := os.Stat(listDir); !os.IsNotExist(err) {
+		t.Fatalf("expected no directory, err %v", err)
+	}
+
+	list.Set([]string{"a"})
+	list.Close()
+	list = NewFileWordlist(listDir, "en_US")
+	exp := []string{"a"}
+
+	if values, err := list.Get(10, 0); !reflect.DeepEqual(values, exp) || err != nil {
+		t.Fatalf("expected %v got %v, err %v", exp, values, err)
+	}
+}
+
+// tornFile writes half of the data of a write and fails.
+type tornFile struct {
+	*os.File
+}
+
+func (f *tornFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestFileWordlistFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wordlist")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	list := NewFileWordlist(dir, "en_US")
+	list.Set([]string{"a"})
+	journal := list.journal.(*os.File)
+	list.journal = &tornFile{journal}
+
+	if err := list.Set([]string{"b"}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+
+	list.journal = journal
+	list.Set([]string{"c"})
+	list.Close()
+
+	list = NewFileWordlist(dir, "en_US")
+	exp := []string{"a", "c"}
+
+	if values, err := list.Get(10, 0); !reflect.DeepEqual(values, exp) || err != nil {
+		t.Fatalf("expected %v got %v, err %v", exp, values, err)
+	}
+}
+
+func TestWordlistEntries(t *testing.T) {
+	once.Do(initBackend)
+
+	entries := []Entry{
+		{Word: "b", Severity: Severe, Categories: []string{"slur"}},
+		{Word: "c"},
+		{Word: "a", Severity: Mild, Categories: []string{"sexual", "drugs"}},
+	}
+
+	for _, list := range backends {
+		test := &TestCase{name: "entries", list: list}
+
+		if err := list.ReplaceEntries(entries); err != nil {
+			t.Fatalf("%s: expected nil got %v", test, err)
+		}
+
+		// ordered by severity, then word
+		exp := []Entry{entries[1], entries[2], entries[0]}
+
+		if values, err := list.Entries(); !reflect.DeepEqual(values, exp) || err != nil {
+			t.Fatalf("%s: expected %v got %v, err %v", test, exp, values, err)
+		}
+
+		if values, err := list.Get(10, 0); !reflect.DeepEqual(values, []string{"c", "a", "b"}) || err != nil {
+			t.Fatalf("%s: expected [c a b] got %v, err %v", test, values, err)
+		}
+
+		// Set overwrites severity and categories
+		list.Set([]string{"b"})
+		exp = []Entry{{Word: "b"}, entries[1], entries[2]}
+
+		if values, err := list.Entries(); !reflect.DeepEqual(values, exp) || err != nil {
+			t.Fatalf("%s: expected %v got %v, err %v", test, exp, values, err)
+		}
+
+		list.Delete([]string{"a"})
+		exp = exp[:2]
+
+		if values, err := list.Entries(); !reflect.DeepEqual(values, exp) || err != nil {
+			t.Fatalf("%s: expected %v got %v, err %v", test, exp, values, err)
+		}
+
+		list.Empty()
+	}
+}
+
+func TestFileWordlistEntriesRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wordlist")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	entries := []Entry{{Word: "a"}, {Word: "b", Severity: Strong, Categories: []string{"violence"}}}
+	list := NewFileWordlist(dir, "en_US")
+	list.CompactEvery = 2
+	list.ReplaceEntries(entries[:1])
+	list.SetEntries(entries[1:])
+	list.Close()
+
+	// compacted snapshot with plain words and entries
+	data, err := ioutil.ReadFile(list.snapshotPath)
+
+	if exp := `["a",{"word":"b","severity":"strong","categories":["violence"]}]` + "\n"; string(data) != exp || err != nil {
+		t.Fatalf("expected snapshot %s got %s, err %v", exp, data, err)
+	}
+
+	list = NewFileWordlist(dir, "en_US")
+
+	if values, err := list.Entries(); !reflect.DeepEqual(values, entries) || err != nil {
+		t.Fatalf("expected %v got %v, err %v", entries, values, err)
+	}
+}
+
+func TestUnionWordlist(t *testing.T) {
+	a := NewMemoryWordlist()
+	a.SetEntries([]Entry{{Word: "x", Severity: Mild, Categories: []string{"slur"}}, {Word: "y"}})
+	b := NewMemoryWordlist()
+	b.SetEntries([]Entry{{Word: "x", Severity: Severe, Categories: []string{"violence", "slur"}}, {Word: "z"}})
+
+	list := NewUnionWordlist(a, b)
+	exp := []Entry{{Word: "y"}, {Word: "z"}, {Word: "x", Severity: Severe, Categories: []string{"slur", "violence"}}}
+
+	if values, err := list.Entries(); !reflect.DeepEqual(values, exp) || err != nil {
+		t.Fatalf("expected %v got %v, err %v", exp, values, err)
+	}
+
+	if values, err := list.Get(2, 1); !reflect.DeepEqual(values, []string{"z", "x"}) || err != nil {
+		t.Fatalf("expected [z x] got %v, err %v", values, err)
+	}
+
+	if err := list.Set([]string{"w"}); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly got %v", err)
+	}
+}
+
+func TestRedisKeys(t *testing.T) {
+	tests := []struct {
+		list *RedisWordlist
+		key  string
+	}{
+		{NewRedisWordlist(nil, "en_US"), "profanity:wordlist:en_US"},
+		{NewRedisAllowlist(nil, "en_US"), "profanity:allowlist:en_US"},
+		{NewRedisNamedWordlist(nil, "kids", "en_US"), "profanity:list:kids:en_US"},
+		{NewRedisTenantWordlist(NewRedisWordlist(nil, "en_US"), "team-a"), "profanity:tenant:team-a:wordlist:en_US"},
+		{NewRedisTenantWordlist(NewRedisNamedWordlist(nil, "kids", "en_US"), "team-a"), "profanity:tenant:team-a:list:kids:en_US"},
+	}
+
+	for i, x := range tests {
+		if x.list.key != x.key || x.list.catKey != x.key+":categories" {
+			t.Fatalf("#%d: expected %s got %s", i, x.key, x.list.key)
+		}
+	}
+}